@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/okex/infura-service/rpc"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	flagConfig = "config"
+	envPrefix  = "INFURA"
+	maskedText = "******"
+)
+
+// secretFiles are the flags of the secrets and their file flags, e.g. for mounted kubernetes secrets.
+// The secrets are masked when the config is printed.
+var secretFiles = map[string]string{
+	flagMysqlPass:    flagMysqlPassFile,
+	flagRedisAuth:    flagRedisAuthFile,
+	flagSentinelAuth: flagSentinelAuthFile,
+	flagAPIKeys:      flagAPIKeysFile,
+}
+
+// readSecret returns the secret of the flag, read from its file if the file flag is set
+func readSecret(v *viper.Viper, name string) (string, error) {
+	fileFlag := secretFiles[name]
+	if path := v.GetString(fileFlag); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %s", fileFlag, err.Error())
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return v.GetString(name), nil
+}
+
+// loadConfig binds the flags, the INFURA_<FLAG> env vars(e.g. INFURA_MYSQL_URL for mysql-url) and the
+// config file to v. The flags set in command line take precedence over the env vars, and then the file.
+func loadConfig(v *viper.Viper, flags *pflag.FlagSet) error {
+	if err := v.BindPFlags(flags); err != nil {
+		return err
+	}
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+	if path := v.GetString(flagConfig); path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s: %s", path, err.Error())
+		}
+	}
+	return nil
+}
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "inspect the configuration of infura service",
+	}
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "print the effective config of infura start in yaml with the secrets masked, it fails if the config is invalid",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadConfig(viper.GetViper(), cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return printConfig(cmd.Flags())
+		},
+	}
+	bindStartFlags(printCmd)
+	cmd.AddCommand(printCmd)
+	return cmd
+}
+
+func printConfig(flags *pflag.FlagSet) error {
+	v := viper.GetViper()
+	values := make(map[string]interface{})
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == flagConfig || f.Name == "help" {
+			return
+		}
+		var value interface{}
+		switch f.Value.Type() {
+		case "bool":
+			value = v.GetBool(f.Name)
+		case "int", "int64":
+			value = v.GetInt64(f.Name)
+		case "float64":
+			value = v.GetFloat64(f.Name)
+		case "duration":
+			value = v.GetDuration(f.Name).String()
+		default:
+			value = maskURL(v.GetString(f.Name))
+		}
+		if _, ok := secretFiles[f.Name]; ok && v.GetString(f.Name) != "" {
+			value = maskedText
+		}
+		values[f.Name] = value
+	})
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(out)
+	config, err := initConfig(v)
+	if err != nil {
+		return err
+	}
+	return rpc.ValidateConfig(config)
+}
+
+// maskURL masks the password in the user info of a url
+func maskURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/migration"
+	"github.com/okex/infura-service/rpc"
+	"github.com/okex/infura-service/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagMigrateTo    = "to"
+	flagMigrateSteps = "steps"
+)
+
+func migrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "create and upgrade the infura tables, same as migrate up",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadConfig(viper.GetViper(), cmd.Flags())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateUp(0)
+		},
+	}
+	bindDBFlags(cmd.PersistentFlags())
+
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "apply the pending migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateUp(viper.GetInt64(flagMigrateTo))
+		},
+	}
+	upCmd.Flags().Int64(flagMigrateTo, 0, "Target version, 0 means the latest version")
+
+	downCmd := &cobra.Command{
+		Use:   "down",
+		Short: "roll back the latest applied migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateDown(viper.GetInt(flagMigrateSteps))
+		},
+	}
+	downCmd.Flags().Int(flagMigrateSteps, 1, "Number of migrations to roll back")
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the applied and pending migrations",
+		Run: func(cmd *cobra.Command, args []string) {
+			migrateStatus()
+		},
+	}
+	cmd.AddCommand(upCmd, downCmd, statusCmd)
+	return cmd
+}
+
+func newMigrator() *migration.Migrator {
+	pass, err := readSecret(viper.GetViper(), flagMysqlPass)
+	if err != nil {
+		log.Crit("failed to read mysql password", "err", err)
+	}
+	driver := viper.GetString(flagDBDriver)
+	if driver != store.DriverSQLite {
+		if err := rpc.ValidateMysqlPass(viper.GetString(flagProfile), pass); err != nil {
+			log.Crit("invalid mysql password", "err", err)
+		}
+	}
+	orm, err := store.OpenOrm(driver, store.Config{
+		Addr:     viper.GetString(flagMysqlUrl),
+		User:     viper.GetString(flagMysqlUser),
+		Password: pass,
+		DBName:   viper.GetString(flagMysqlDB),
+		SSLMode:  viper.GetString(flagPostgresSSLMode),
+	})
+	if err != nil {
+		log.Crit("failed to open database", "driver", driver, "err", err)
+	}
+	return migration.NewMigrator(orm.DB())
+}
+
+func migrateUp(target int64) {
+	done, err := newMigrator().Up(target)
+	for _, m := range done {
+		log.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		log.Crit("failed to apply migrations", "err", err)
+	}
+	if len(done) == 0 {
+		log.Info("no pending migrations")
+	}
+}
+
+func migrateDown(steps int) {
+	done, err := newMigrator().Down(steps)
+	for _, m := range done {
+		log.Info("rolled back migration", "version", m.Version, "name", m.Name)
+	}
+	if err != nil {
+		log.Crit("failed to roll back migrations", "err", err)
+	}
+}
+
+func migrateStatus() {
+	statuses, err := newMigrator().Status()
+	if err != nil {
+		log.Crit("failed to read migration status", "err", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		if s.Applied {
+			fmt.Fprintf(w, "%d\t%s\tapplied\t%s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Fprintf(w, "%d\t%s\tpending\t-\n", s.Version, s.Name)
+		}
+	}
+	w.Flush()
+}
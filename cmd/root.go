@@ -1,22 +1,35 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"os"
 
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/logger"
 	"github.com/spf13/cobra"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "infura [command]",
 	Short: "infura is a centralized service for improving rpc performance.",
+	// the error is printed by Execute
+	SilenceErrors: true,
 }
 
 func Execute() {
+	// 加载配置前的默认日志，start按配置重新初始化
+	if err := logger.Init("info", logger.FormatTerminal, 0); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+		log.Crit("failed to execute command", "err", err)
 	}
 }
 
 func init() {
+	rootCmd.PersistentFlags().String(flagConfig, "", "Config file in yaml, toml or json, the keys are the flag names")
 	rootCmd.AddCommand(startCmd())
+	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(configCmd())
 }
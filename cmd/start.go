@@ -1,34 +1,96 @@
 package cmd
 
 import (
-	"log"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/nacos"
+	"github.com/okex/infura-service/redis"
 	"github.com/okex/infura-service/rpc"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
+	flagProfile             = "profile"
 	flagAddress             = "address"
 	flagNacosUrl            = "nacos_url"
 	flagNacosNamespaceID    = "nacos_namespace_id"
 	flagNacosServiceName    = "nacos-service-name"
 	flagNacosServiceAddress = "nacos_service_address"
+	flagNacosConfigDataID   = "nacos-config-data-id"
+	flagNacosConfigGroup    = "nacos-config-group"
+	flagNacosConfigFormat   = "nacos-config-format"
+	flagDBDriver            = "db-driver"
 	flagMysqlUrl            = "mysql-url"
 	flagMysqlUser           = "mysql-user"
 	flagMysqlPass           = "mysql-pass"
+	flagMysqlPassFile       = "mysql-pass-file"
 	flagMysqlDB             = "mysql-db"
+	flagPostgresSSLMode     = "postgres-sslmode"
+	flagMysqlReplicas       = "mysql-replicas"
+	flagMysqlReplicaMaxLag  = "mysql-replica-max-lag"
+	flagMysqlMaxOpenConns   = "mysql-max-open-conns"
+	flagMysqlMaxIdleConns   = "mysql-max-idle-conns"
+	flagMysqlConnLifetime   = "mysql-conn-max-lifetime"
+	flagMysqlConnIdleTime   = "mysql-conn-max-idle-time"
+	flagRedisMode           = "redis-mode"
 	flagRedisUrl            = "redis-url"
+	flagRedisMasterName     = "redis-master-name"
 	flagRedisAuth           = "redis-auth"
+	flagRedisAuthFile       = "redis-auth-file"
+	flagSentinelAuth        = "redis-sentinel-auth"
+	flagSentinelAuthFile    = "redis-sentinel-auth-file"
 	flagRedisDB             = "redis-db"
+	flagRedisTLS            = "redis-tls"
+	flagRedisTLSCAFile      = "redis-tls-ca-file"
+	flagRedisTLSServerName  = "redis-tls-server-name"
+	flagRedisPoolSize       = "redis-pool-size"
+	flagRedisMinIdleConns   = "redis-min-idle-conns"
+	flagRedisPoolTimeout    = "redis-pool-timeout"
+	flagRedisIdleTimeout    = "redis-idle-timeout"
+	flagChainID             = "chain-id"
+	flagUpstreamUrl         = "upstream-url"
+	flagPersistLogsBloom    = "persist-logs-bloom"
+	flagMaxLogs             = "max-logs"
+	flagMaxBlockRange       = "max-block-range"
+	flagFinalityDepth       = "finality-depth"
+	flagCacheSize           = "cache-size"
+	flagCacheTTL            = "cache-ttl"
+	flagMaxIndexerLag       = "max-indexer-lag"
+	flagNacosWeight         = "nacos-weight"
+	flagShutdownDelay       = "shutdown-delay"
+	flagShutdownTimeout     = "shutdown-timeout"
+	flagRateLimit           = "rate-limit"
+	flagRateLimitLogs       = "rate-limit-logs"
+	flagRateLimitRedis      = "rate-limit-redis"
+	flagAPIKeys             = "api-keys"
+	flagAPIKeysFile         = "api-keys-file"
+	flagAPIKeyRequired      = "api-key-required"
+	flagTrustedProxies      = "trusted-proxies"
+	flagWSOrigins           = "ws-origins"
+	flagLogLevel            = "log-level"
+	flagLogFormat           = "log-format"
+	flagSlowQueryThreshold  = "slow-query-threshold"
+	flagTraceEndpoint       = "trace-endpoint"
+	flagTraceSampleRatio    = "trace-sample-ratio"
 )
 
 func startCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "start infura service",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadConfig(viper.GetViper(), cmd.Flags())
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			starService()
+			starService(cmd.Flags())
 		},
 	}
 	bindStartFlags(cmd)
@@ -41,38 +103,201 @@ func bindStartFlags(cmd *cobra.Command) {
 	cmd.Flags().String(flagNacosNamespaceID, "", "Nacos namespace id for discovery of rpc service")
 	cmd.Flags().String(flagNacosServiceName, "", "Rpc service name in nacos")
 	cmd.Flags().String(flagNacosServiceAddress, "127.0.0.1:8080", "Rpc service address register to nacos")
-	cmd.Flags().String(flagMysqlUrl, "127.0.0.1:3306", "Mysql url(host:port) of rpc service")
-	cmd.Flags().String(flagMysqlUser, "root", "Mysql user of rpc service")
-	cmd.Flags().String(flagMysqlPass, "root", "Mysql password of rpc service")
-	cmd.Flags().String(flagMysqlDB, "infura", "Mysql db name of rpc service")
-	cmd.Flags().String(flagRedisUrl, "127.0.0.1:6379", "Redis url(host:port) of infura rpc service")
-	cmd.Flags().String(flagRedisAuth, "", "Redis auth of rpc service")
-	cmd.Flags().Int(flagRedisDB, 0, "Redis db of rpc service")
-	viper.BindPFlags(cmd.Flags())
+	cmd.Flags().Float64(flagNacosWeight, 10, "Rpc service weight register to nacos")
+	cmd.Flags().String(flagNacosConfigDataID, "", "Nacos config data id of rpc service, the safe values are reloaded on change")
+	cmd.Flags().String(flagNacosConfigGroup, "DEFAULT_GROUP", "Nacos config group of rpc service")
+	cmd.Flags().String(flagNacosConfigFormat, "yaml", "Nacos config format of rpc service: yaml, json or toml, keys are the flag names")
+	bindDBFlags(cmd.Flags())
+	// 只读副本和连接池参数仅用于start，migrate总是连接主库
+	cmd.Flags().String(flagMysqlReplicas, "", "Comma separated urls(host:port) of the read replicas, with the same user, password and db as the primary")
+	cmd.Flags().Int64(flagMysqlReplicaMaxLag, 10, "Max blocks a read replica may be behind the primary before it is ejected, 0 to disable")
+	cmd.Flags().Int(flagMysqlMaxOpenConns, 100, "Max open connections to the primary and to each replica, 0 for no limit")
+	cmd.Flags().Int(flagMysqlMaxIdleConns, 20, "Max idle connections to the primary and to each replica, 0 for the default of 2")
+	cmd.Flags().Duration(flagMysqlConnLifetime, 30*time.Minute, "Max lifetime of a database connection, 0 for no limit")
+	cmd.Flags().Duration(flagMysqlConnIdleTime, 5*time.Minute, "Max idle time of a database connection, 0 for no limit")
+	cmd.Flags().String(flagRedisMode, redis.ModeStandalone, "Redis mode of rpc service: standalone, sentinel or cluster")
+	cmd.Flags().String(flagRedisUrl, "127.0.0.1:6379", "Redis url(host:port) of infura rpc service, comma separated sentinels or cluster nodes in the sentinel and cluster modes")
+	cmd.Flags().String(flagRedisMasterName, "", "Redis master name monitored by the sentinels")
+	cmd.Flags().String(flagRedisAuth, "", "Redis auth of rpc service, prefer redis-auth-file or INFURA_REDIS_AUTH as flags are visible in ps")
+	cmd.Flags().String(flagRedisAuthFile, "", "File containing the redis auth, it takes precedence over redis-auth")
+	cmd.Flags().String(flagSentinelAuth, "", "Redis auth of the sentinels if it differs from redis-auth, prefer redis-sentinel-auth-file or INFURA_REDIS_SENTINEL_AUTH")
+	cmd.Flags().String(flagSentinelAuthFile, "", "File containing the redis sentinel auth, it takes precedence over redis-sentinel-auth")
+	cmd.Flags().Int(flagRedisDB, 0, "Redis db of rpc service, must be 0 in the cluster mode")
+	cmd.Flags().Bool(flagRedisTLS, false, "Connect to redis over tls")
+	cmd.Flags().String(flagRedisTLSCAFile, "", "File of the ca certificates verifying redis, the system cas are used if empty")
+	cmd.Flags().String(flagRedisTLSServerName, "", "Server name verified in the redis certificates, the host of redis-url if empty")
+	cmd.Flags().Int(flagRedisPoolSize, 0, "Max connections to each redis node, 0 for 10 per cpu")
+	cmd.Flags().Int(flagRedisMinIdleConns, 0, "Min idle connections to each redis node")
+	cmd.Flags().Duration(flagRedisPoolTimeout, 0, "Max time to wait for a redis connection when all are busy, 0 for read timeout + 1s")
+	cmd.Flags().Duration(flagRedisIdleTimeout, 0, "Idle redis connections are closed after this, 0 for 5m")
+	cmd.Flags().Int64(flagChainID, 66, "Chain id returned by eth_chainId and net_version")
+	cmd.Flags().String(flagUpstreamUrl, "", "Full node rpc url for the methods and data not served by infura")
+	cmd.Flags().Int(flagMaxLogs, 10000, "Max number of logs returned by eth_getLogs, 0 for no limit")
+	cmd.Flags().Int64(flagMaxBlockRange, 0, "Max block range of eth_getLogs, 0 for no limit")
+	cmd.Flags().Int64(flagFinalityDepth, 0, "Number of blocks behind the latest of the safe and finalized block tags, 0 as tendermint blocks are final once committed")
+	cmd.Flags().Int(flagCacheSize, 10000, "Number of finalized blocks, receipts and code cached in process, 0 to disable")
+	cmd.Flags().Duration(flagCacheTTL, time.Hour, "Expiry of finalized blocks, receipts and code cached in redis, 0 to disable")
+	cmd.Flags().Bool(flagPersistLogsBloom, false, "Save the computed block logs bloom in mysql, requires migration 3")
+	cmd.Flags().Duration(flagShutdownDelay, 5*time.Second, "Time to keep serving after deregistering from nacos on shutdown")
+	cmd.Flags().Duration(flagShutdownTimeout, 30*time.Second, "Max time to drain the in-flight requests on shutdown")
+	cmd.Flags().Duration(flagMaxIndexerLag, 5*time.Minute, "Service is not ready if the latest indexed block is older than this, 0 to disable")
+	cmd.Flags().Float64(flagRateLimit, 0, "Requests per second of a caller(api key or ip), 0 for unlimited")
+	cmd.Flags().Float64(flagRateLimitLogs, 0, "Requests per second of a caller for eth_getLogs and filter logs, 0 for unlimited")
+	cmd.Flags().Bool(flagRateLimitRedis, false, "Keep the rate limits in redis so that they hold across replicas")
+	cmd.Flags().String(flagAPIKeys, "", "Comma separated api keys accepted in the path(/<key>) or the X-Api-Key header")
+	cmd.Flags().String(flagAPIKeysFile, "", "File containing the api keys separated by commas or new lines, it takes precedence over api-keys")
+	cmd.Flags().Bool(flagAPIKeyRequired, false, "Reject the requests without a valid api key")
+	cmd.Flags().String(flagTrustedProxies, "", "Comma separated ips or cidrs of the proxies whose X-Forwarded-For is the client ip of the rate limits, empty to use the remote address")
+	cmd.Flags().String(flagWSOrigins, "*", "Comma separated origins(e.g. https://app.example.com) allowed to open websockets from browsers, * for any")
+	cmd.Flags().String(flagLogLevel, "info", "Log level: trace, debug, info, warn, error or crit, every sql is logged at debug")
+	cmd.Flags().String(flagLogFormat, logger.FormatJSON, "Log format: json or terminal")
+	cmd.Flags().Duration(flagSlowQueryThreshold, 200*time.Millisecond, "Sql queries slower than this are logged at warn, 0 to disable")
+	cmd.Flags().String(flagTraceEndpoint, "", "OTLP http endpoint of the traces, e.g. http://127.0.0.1:4318, empty to disable tracing")
+	cmd.Flags().Float64(flagTraceSampleRatio, 1, "Ratio of the traces sampled, the decision of the gateway in traceparent is kept")
+}
+
+func bindDBFlags(flags *pflag.FlagSet) {
+	flags.String(flagProfile, rpc.ProfileProd, "Profile of the service: dev or prod, an empty or the default mysql password is only allowed in dev")
+	flags.String(flagDBDriver, "mysql", "Database driver of rpc service: mysql, postgres or sqlite(mysql-db is used as the file path)")
+	flags.String(flagMysqlUrl, "127.0.0.1:3306", "Mysql url(host:port) of rpc service")
+	flags.String(flagMysqlUser, "root", "Mysql user of rpc service")
+	flags.String(flagMysqlPass, "", "Mysql password of rpc service, prefer mysql-pass-file or INFURA_MYSQL_PASS as flags are visible in ps")
+	flags.String(flagMysqlPassFile, "", "File containing the mysql password, it takes precedence over mysql-pass")
+	flags.String(flagMysqlDB, "infura", "Mysql db name of rpc service")
+	flags.String(flagPostgresSSLMode, "prefer", "Sslmode of postgres: disable, allow, prefer, require, verify-ca or verify-full")
 }
 
-func starService() {
-	config := initConfig()
+func starService(flags *pflag.FlagSet) {
+	config, err := initConfig(viper.GetViper())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// 先按命令行参数初始化日志，nacos配置加载后在rpc.New中重新初始化
+	if err := logger.Init(config.LogLevel, config.LogFormat, config.SlowQueryThreshold); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var source *nacos.ConfigSource
+	if dataID := viper.GetString(flagNacosConfigDataID); dataID != "" {
+		source, err = nacos.NewConfigSource(config.NacosUrl, config.NacosNamespaceId, dataID, viper.GetString(flagNacosConfigGroup))
+		if err != nil {
+			log.Crit("failed to create nacos config source", "err", err)
+		}
+		content, err := source.Get()
+		if err != nil {
+			log.Crit("failed to get config from nacos", "data_id", dataID, "err", err)
+		}
+		if config, err = parseRemoteConfig(flags, content); err != nil {
+			log.Crit("failed to parse config from nacos", "data_id", dataID, "err", err)
+		}
+	}
+
 	service, err := rpc.New(config)
 	if err != nil {
-		log.Fatal(err)
+		log.Crit("failed to create service", "err", err)
+	}
+	if source != nil {
+		err := service.Watch(source, func(content string) (*rpc.Config, error) {
+			return parseRemoteConfig(flags, content)
+		})
+		if err != nil {
+			log.Crit("failed to watch nacos config", "err", err)
+		}
 	}
 	service.Start()
 }
 
-func initConfig() *rpc.Config {
-	return &rpc.Config{
-		Address:          viper.GetString(flagAddress),
-		NacosUrl:         viper.GetString(flagNacosUrl),
-		NacosNamespaceId: viper.GetString(flagNacosNamespaceID),
-		NacosServiceName: viper.GetString(flagNacosServiceName),
-		NacosServiceAddr: viper.GetString(flagNacosServiceAddress),
-		MysqlUrl:         viper.GetString(flagMysqlUrl),
-		MysqlUser:        viper.GetString(flagMysqlUser),
-		MysqlPass:        viper.GetString(flagMysqlPass),
-		MysqlDB:          viper.GetString(flagMysqlDB),
-		RedisUrl:         viper.GetString(flagRedisUrl),
-		RedisAuth:        viper.GetString(flagRedisAuth),
-		RedisDB:          viper.GetInt(flagRedisDB),
+// parseRemoteConfig builds the config from the content of the nacos config data id merged into
+// the config file, the flags set in command line and the env vars take precedence over it
+func parseRemoteConfig(flags *pflag.FlagSet, content string) (*rpc.Config, error) {
+	v := viper.New()
+	if err := loadConfig(v, flags); err != nil {
+		return nil, err
+	}
+	v.SetConfigType(viper.GetString(flagNacosConfigFormat))
+	if err := v.MergeConfig(strings.NewReader(content)); err != nil {
+		return nil, err
+	}
+	return initConfig(v)
+}
+
+func initConfig(v *viper.Viper) (*rpc.Config, error) {
+	mysqlPass, err := readSecret(v, flagMysqlPass)
+	if err != nil {
+		return nil, err
+	}
+	redisAuth, err := readSecret(v, flagRedisAuth)
+	if err != nil {
+		return nil, err
+	}
+	sentinelAuth, err := readSecret(v, flagSentinelAuth)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := readSecret(v, flagAPIKeys)
+	if err != nil {
+		return nil, err
 	}
+	return &rpc.Config{
+		Profile:            v.GetString(flagProfile),
+		Address:            v.GetString(flagAddress),
+		NacosUrl:           v.GetString(flagNacosUrl),
+		NacosNamespaceId:   v.GetString(flagNacosNamespaceID),
+		NacosServiceName:   v.GetString(flagNacosServiceName),
+		NacosServiceAddr:   v.GetString(flagNacosServiceAddress),
+		NacosWeight:        v.GetFloat64(flagNacosWeight),
+		DBDriver:           v.GetString(flagDBDriver),
+		MysqlUrl:           v.GetString(flagMysqlUrl),
+		MysqlUser:          v.GetString(flagMysqlUser),
+		MysqlPass:          mysqlPass,
+		MysqlDB:            v.GetString(flagMysqlDB),
+		PostgresSSLMode:    v.GetString(flagPostgresSSLMode),
+		MysqlReplicas:      v.GetString(flagMysqlReplicas),
+		MysqlReplicaMaxLag: v.GetInt64(flagMysqlReplicaMaxLag),
+		MysqlMaxOpenConns:  v.GetInt(flagMysqlMaxOpenConns),
+		MysqlMaxIdleConns:  v.GetInt(flagMysqlMaxIdleConns),
+		MysqlConnLifetime:  v.GetDuration(flagMysqlConnLifetime),
+		MysqlConnIdleTime:  v.GetDuration(flagMysqlConnIdleTime),
+		RedisMode:          v.GetString(flagRedisMode),
+		RedisUrl:           v.GetString(flagRedisUrl),
+		RedisMasterName:    v.GetString(flagRedisMasterName),
+		RedisAuth:          redisAuth,
+		RedisSentinelAuth:  sentinelAuth,
+		RedisDB:            v.GetInt(flagRedisDB),
+		RedisTLS:           v.GetBool(flagRedisTLS),
+		RedisTLSCAFile:     v.GetString(flagRedisTLSCAFile),
+		RedisTLSServerName: v.GetString(flagRedisTLSServerName),
+		RedisPoolSize:      v.GetInt(flagRedisPoolSize),
+		RedisMinIdleConns:  v.GetInt(flagRedisMinIdleConns),
+		RedisPoolTimeout:   v.GetDuration(flagRedisPoolTimeout),
+		RedisIdleTimeout:   v.GetDuration(flagRedisIdleTimeout),
+		ChainID:            v.GetInt64(flagChainID),
+		UpstreamUrl:        v.GetString(flagUpstreamUrl),
+		PersistLogsBloom:   v.GetBool(flagPersistLogsBloom),
+		MaxLogs:            v.GetInt(flagMaxLogs),
+		MaxBlockRange:      v.GetInt64(flagMaxBlockRange),
+		FinalityDepth:      v.GetInt64(flagFinalityDepth),
+		CacheSize:          v.GetInt(flagCacheSize),
+		CacheTTL:           v.GetDuration(flagCacheTTL),
+		MaxIndexerLag:      v.GetDuration(flagMaxIndexerLag),
+		ShutdownDelay:      v.GetDuration(flagShutdownDelay),
+		ShutdownTimeout:    v.GetDuration(flagShutdownTimeout),
+		RateLimit:          v.GetFloat64(flagRateLimit),
+		RateLimitLogs:      v.GetFloat64(flagRateLimitLogs),
+		RateLimitRedis:     v.GetBool(flagRateLimitRedis),
+		APIKeys:            strings.Join(strings.FieldsFunc(apiKeys, isKeySeparator), ","),
+		APIKeyRequired:     v.GetBool(flagAPIKeyRequired),
+		TrustedProxies:     v.GetString(flagTrustedProxies),
+		WSOrigins:          v.GetString(flagWSOrigins),
+		LogLevel:           v.GetString(flagLogLevel),
+		LogFormat:          v.GetString(flagLogFormat),
+		SlowQueryThreshold: v.GetDuration(flagSlowQueryThreshold),
+		TraceEndpoint:      v.GetString(flagTraceEndpoint),
+		TraceSampleRatio:   v.GetFloat64(flagTraceSampleRatio),
+	}, nil
+}
+
+func isKeySeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
 }
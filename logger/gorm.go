@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// gormLogger writes the failed and slow queries to the service logger with the request id,
+// every query is logged only at debug level
+type gormLogger struct{}
+
+// Gorm returns the logger of gorm
+func Gorm() gormlogger.Interface {
+	return gormLogger{}
+}
+
+func (l gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Info(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Warn(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	FromContext(ctx).Error(fmt.Sprintf(msg, data...))
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	slow := time.Duration(atomic.LoadInt64(&slowQuery))
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		FromContext(ctx).Error("query failed", "sql", sql, "rows", rows, "elapsed", elapsed, "err", err)
+	case slow > 0 && elapsed > slow:
+		sql, rows := fc()
+		FromContext(ctx).Warn("slow query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	case Enabled(log.LvlDebug):
+		sql, rows := fc()
+		FromContext(ctx).Debug("query", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
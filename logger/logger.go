@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatTerminal = "terminal"
+)
+
+var (
+	level     = int32(log.LvlInfo)
+	slowQuery = int64(200 * time.Millisecond)
+)
+
+// Init sets the handler of the go-ethereum root logger, which is the logger of the whole service.
+// level is one of trace, debug, info, warn, error and crit, queries slower than slow are logged
+// with warn level, 0 to disable.
+func Init(lvl string, format string, slow time.Duration) error {
+	l, fmtr, err := parse(lvl, format)
+	if err != nil {
+		return err
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(l, log.StreamHandler(os.Stdout, fmtr)))
+	atomic.StoreInt32(&level, int32(l))
+	atomic.StoreInt64(&slowQuery, int64(slow))
+	return nil
+}
+
+func parse(lvl string, format string) (log.Lvl, log.Format, error) {
+	l, err := log.LvlFromString(lvl)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch format {
+	case FormatJSON:
+		return l, log.JSONFormat(), nil
+	case FormatTerminal:
+		return l, log.TerminalFormat(false), nil
+	}
+	return 0, nil, fmt.Errorf("unsupported log format %s", format)
+}
+
+// Enabled reports whether the records of the level are logged
+func Enabled(lvl log.Lvl) bool {
+	return lvl <= log.Lvl(atomic.LoadInt32(&level))
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random id of a request
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns the context carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id of the context, empty if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns the logger with the request id and the trace id of the context
+func FromContext(ctx context.Context) log.Logger {
+	var fields []interface{}
+	if id := RequestID(ctx); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+	}
+	if len(fields) == 0 {
+		return log.Root()
+	}
+	return log.New(fields...)
+}
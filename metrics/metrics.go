@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "infura"
+
+var (
+	rpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "requests_total",
+		Help:      "Number of json-rpc requests by method.",
+	}, []string{"method"})
+	rpcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "errors_total",
+		Help:      "Number of json-rpc requests answered with an error by method.",
+	}, []string{"method"})
+	rpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of json-rpc requests by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "orm",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of orm queries by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	queryRows = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "orm",
+		Name:      "query_rows",
+		Help:      "Number of rows returned by orm queries by method.",
+		Buckets:   []float64{0, 1, 10, 100, 1000, 10000},
+	}, []string{"method"})
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "orm",
+		Name:      "errors_total",
+		Help:      "Number of failed orm queries by method.",
+	}, []string{"method"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Latency of redis commands.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "errors_total",
+		Help:      "Number of failed redis commands.",
+	}, []string{"command"})
+
+	replicaHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "orm",
+		Name:      "replica_healthy",
+		Help:      "Whether the read replica receives queries, 0 if it is ejected.",
+	}, []string{"replica"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Number of cache lookups by layer(lru or redis), orm method and result(hit or miss).",
+	}, []string{"layer", "method", "result"})
+)
+
+const (
+	// otherMethod is the label of the json-rpc methods which are not registered
+	otherMethod = "other"
+	// batchMethod is the label of the latency of batch requests
+	batchMethod = "batch"
+)
+
+// methods are the json-rpc methods labelled by name, the method names are sent by clients so
+// anything else is reported as other to bound the label values
+var methods atomic.Value // map[string]bool
+
+// RegisterMethods adds the json-rpc methods labelled by name
+func RegisterMethods(names ...string) {
+	registered := make(map[string]bool)
+	if value, ok := methods.Load().(map[string]bool); ok {
+		for name := range value {
+			registered[name] = true
+		}
+	}
+	for _, name := range names {
+		registered[name] = true
+	}
+	methods.Store(registered)
+}
+
+func methodLabel(method string) string {
+	if registered, _ := methods.Load().(map[string]bool); registered[method] {
+		return method
+	}
+	return otherMethod
+}
+
+func observeCall(method string, failed bool) {
+	rpcRequests.WithLabelValues(method).Inc()
+	if failed {
+		rpcErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ObserveRPC records a json-rpc request of a single call
+func ObserveRPC(method string, duration time.Duration, failed bool) {
+	method = methodLabel(method)
+	observeCall(method, failed)
+	rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// ObserveRPCBatch records the calls of a batch request. The calls are counted by method, the
+// latency is of the whole batch and recorded as the method batch.
+func ObserveRPCBatch(methods []string, failed []bool, duration time.Duration) {
+	for i, method := range methods {
+		observeCall(methodLabel(method), failed[i])
+	}
+	rpcDuration.WithLabelValues(batchMethod).Observe(duration.Seconds())
+}
+
+// ObserveQuery records an orm query started at start
+func ObserveQuery(method string, start time.Time, rows int, err error) {
+	queryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(method).Inc()
+		return
+	}
+	queryRows.WithLabelValues(method).Observe(float64(rows))
+}
+
+// ObserveRedis records a redis command started at start
+func ObserveRedis(command string, start time.Time, err error) {
+	redisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveCache records a lookup of the cache layer
+func ObserveCache(layer string, method string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(layer, method, result).Inc()
+}
+
+// SetReplicaHealthy records whether the read replica receives queries
+func SetReplicaHealthy(replica string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	replicaHealthy.WithLabelValues(replica).Set(value)
+}
+
+// RegisterIndexerLag registers the gauge of the seconds the infura task is behind the wall clock
+func RegisterIndexerLag(lag func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "indexer",
+		Name:      "lag_seconds",
+		Help:      "Seconds between the wall clock and the latest block synced by the infura task.",
+	}, lag)
+}
+
+// Handler returns the http handler of the /metrics endpoint
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
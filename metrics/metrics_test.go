@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveRPCLabels(t *testing.T) {
+	RegisterMethods("eth_blockNumber")
+	RegisterMethods("eth_getLogs")
+
+	ObserveRPC("eth_blockNumber", time.Millisecond, false)
+	ObserveRPC("eth_foo", time.Millisecond, true)
+	ObserveRPCBatch([]string{"eth_getLogs", "eth_bar", "eth_getLogs"}, []bool{false, false, true}, time.Second)
+
+	for method, want := range map[string]float64{"eth_blockNumber": 1, "eth_getLogs": 2, otherMethod: 2, "eth_foo": 0, "eth_bar": 0} {
+		if got := testutil.ToFloat64(rpcRequests.WithLabelValues(method)); got != want {
+			t.Errorf("requests of %s: %v, want %v", method, got, want)
+		}
+	}
+	for method, want := range map[string]float64{"eth_getLogs": 1, otherMethod: 1} {
+		if got := testutil.ToFloat64(rpcErrors.WithLabelValues(method)); got != want {
+			t.Errorf("errors of %s: %v, want %v", method, got, want)
+		}
+	}
+	// the batch latency is not attributed to its calls
+	if got := testutil.CollectAndCount(rpcDuration); got != 3 {
+		t.Errorf("got %d latency series, want eth_blockNumber, other and batch", got)
+	}
+}
@@ -0,0 +1,148 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+// createTables is the schema of the exchain models for postgres and sqlite, as the models declare
+// mysql column types and the index unique_hash twice, which is not allowed outside mysql.
+// {id} is the type of the primary keys and {time} the type of the timestamps.
+var createTables = []string{
+	`CREATE TABLE blocks (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		number bigint NOT NULL,
+		hash varchar(66) NOT NULL,
+		parent_hash varchar(66),
+		transactions_root varchar(66),
+		state_root varchar(66),
+		miner varchar(42),
+		size bigint,
+		gas_limit bigint,
+		gas_used bigint,
+		"timestamp" bigint
+	)`,
+	`CREATE UNIQUE INDEX idx_blocks_hash_unique ON blocks (hash)`,
+	`CREATE INDEX idx_blocks_deleted_at ON blocks (deleted_at)`,
+	`CREATE INDEX idx_blocks_number ON blocks (number)`,
+
+	`CREATE TABLE transactions (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		block_hash varchar(66),
+		block_number bigint,
+		"from" varchar(42),
+		gas bigint,
+		gas_price varchar(66),
+		hash varchar(66),
+		input text,
+		nonce bigint,
+		"to" varchar(42),
+		"index" bigint,
+		value varchar(255),
+		v varchar(255),
+		r varchar(255),
+		s varchar(255),
+		block_id bigint REFERENCES blocks (id)
+	)`,
+	`CREATE INDEX idx_transactions_deleted_at ON transactions (deleted_at)`,
+
+	`CREATE TABLE transaction_receipts (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		status smallint,
+		cumulative_gas_used bigint,
+		transaction_hash varchar(66) NOT NULL,
+		contract_address varchar(42),
+		gas_used bigint,
+		block_hash varchar(66),
+		block_number bigint,
+		transaction_index bigint,
+		"from" varchar(42),
+		"to" varchar(42)
+	)`,
+	`CREATE UNIQUE INDEX idx_transaction_receipts_hash_unique ON transaction_receipts (transaction_hash)`,
+	`CREATE INDEX idx_transaction_receipts_deleted_at ON transaction_receipts (deleted_at)`,
+
+	`CREATE TABLE transaction_logs (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		address varchar(42) NOT NULL,
+		data text,
+		transaction_hash varchar(66),
+		transaction_index bigint,
+		log_index bigint,
+		block_hash varchar(66) NOT NULL,
+		block_number bigint NOT NULL,
+		transaction_receipt_id bigint REFERENCES transaction_receipts (id)
+	)`,
+	`CREATE INDEX idx_transaction_logs_deleted_at ON transaction_logs (deleted_at)`,
+	`CREATE INDEX idx_transaction_logs_address ON transaction_logs (address)`,
+	`CREATE INDEX idx_transaction_logs_block_hash ON transaction_logs (block_hash)`,
+	`CREATE INDEX idx_transaction_logs_block_number ON transaction_logs (block_number)`,
+
+	`CREATE TABLE log_topics (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		topic varchar(66),
+		transaction_log_id bigint REFERENCES transaction_logs (id)
+	)`,
+	`CREATE INDEX idx_log_topics_deleted_at ON log_topics (deleted_at)`,
+
+	`CREATE TABLE contract_codes (
+		id {id}, created_at {time}, updated_at {time}, deleted_at {time},
+		address varchar(42) NOT NULL,
+		code text,
+		block_number bigint
+	)`,
+	`CREATE UNIQUE INDEX idx_contract_codes_address_unique ON contract_codes (address)`,
+	`CREATE INDEX idx_contract_codes_deleted_at ON contract_codes (deleted_at)`,
+}
+
+// columnTypes are the primary key and timestamp types of createTables by dialect
+var columnTypes = map[string][2]string{
+	"postgres": {"bigserial PRIMARY KEY", "timestamptz"},
+	"sqlite":   {"integer PRIMARY KEY AUTOINCREMENT", "datetime"},
+}
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			dialect := tx.Dialector.Name()
+			if dialect == "mysql" {
+				// same as scripts/infura.sql
+				return tx.AutoMigrate(
+					&types.Block{},
+					&types.Transaction{},
+					&types.TransactionReceipt{},
+					&types.TransactionLog{},
+					&types.LogTopic{},
+					&types.ContractCode{},
+				)
+			}
+			typ, ok := columnTypes[dialect]
+			if !ok {
+				return fmt.Errorf("unsupported dialect %s", dialect)
+			}
+			replacer := strings.NewReplacer("{id}", typ[0], "{time}", typ[1])
+			for _, stmt := range createTables {
+				if err := tx.Exec(replacer.Replace(stmt)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// 按外键依赖的逆序删除
+			return tx.Migrator().DropTable(
+				&types.LogTopic{},
+				&types.TransactionLog{},
+				&types.TransactionReceipt{},
+				&types.Transaction{},
+				&types.Block{},
+				&types.ContractCode{},
+			)
+		},
+	})
+}
@@ -0,0 +1,23 @@
+package migration
+
+import (
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "transactions_hash_index",
+		Up: func(tx *gorm.DB) error {
+			// scripts/infura.sql already creates the index
+			if tx.Migrator().HasIndex(&types.Transaction{}, "idx_transactions_hash") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_transactions_hash ON transactions (hash)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&types.Transaction{}, "idx_transactions_hash")
+		},
+	})
+}
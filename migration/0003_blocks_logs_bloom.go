@@ -0,0 +1,23 @@
+package migration
+
+import (
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "blocks_logs_bloom",
+		Up: func(tx *gorm.DB) error {
+			// scripts/infura.sql already declares the column
+			if tx.Migrator().HasColumn(&types.Block{}, "logs_bloom") {
+				return nil
+			}
+			return tx.Exec("ALTER TABLE blocks ADD COLUMN logs_bloom varchar(514)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE blocks DROP COLUMN logs_bloom").Error
+		},
+	})
+}
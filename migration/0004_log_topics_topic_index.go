@@ -0,0 +1,24 @@
+package migration
+
+import (
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "log_topics_topic_index",
+		Up: func(tx *gorm.DB) error {
+			// eth_getLogs filters topics in sql. Superseded by idx_log_topics_topic_position of
+			// migration 6 and dropped by migration 7
+			if tx.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_log_topics_topic ON log_topics (topic, transaction_log_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&types.LogTopic{}, "idx_log_topics_topic")
+		},
+	})
+}
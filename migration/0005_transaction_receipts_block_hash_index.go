@@ -0,0 +1,23 @@
+package migration
+
+import (
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "transaction_receipts_block_hash_index",
+		Up: func(tx *gorm.DB) error {
+			// scripts/infura.sql already creates the index
+			if tx.Migrator().HasIndex(&types.TransactionReceipt{}, "idx_transaction_receipts_block_hash") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_transaction_receipts_block_hash ON transaction_receipts (block_hash)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&types.TransactionReceipt{}, "idx_transaction_receipts_block_hash")
+		},
+	})
+}
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"fmt"
+
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+// positionTriggers fill log_topics.position on insert by dialect, as the infura task does not know
+// the column. The position is the number of topics already saved for the log, which is the id order
+// as the topics of a log are saved together. Creating triggers on mysql with binary logging needs
+// SUPER or log_bin_trust_function_creators.
+var positionTriggers = map[string][]string{
+	"mysql": {
+		`DROP TRIGGER IF EXISTS log_topics_position`,
+		`CREATE TRIGGER log_topics_position BEFORE INSERT ON log_topics FOR EACH ROW
+		SET NEW.position = (SELECT COUNT(*) FROM log_topics p
+			WHERE p.transaction_log_id = NEW.transaction_log_id AND p.deleted_at IS NULL)`,
+	},
+	"postgres": {
+		`CREATE OR REPLACE FUNCTION log_topics_position() RETURNS trigger AS $$
+		BEGIN
+			NEW.position := (SELECT COUNT(*) FROM log_topics p
+				WHERE p.transaction_log_id = NEW.transaction_log_id AND p.deleted_at IS NULL);
+			RETURN NEW;
+		END $$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS log_topics_position ON log_topics`,
+		`CREATE TRIGGER log_topics_position BEFORE INSERT ON log_topics FOR EACH ROW EXECUTE PROCEDURE log_topics_position()`,
+	},
+	// sqlite can not assign NEW, the row is updated after insert instead
+	"sqlite": {
+		`DROP TRIGGER IF EXISTS log_topics_position`,
+		`CREATE TRIGGER log_topics_position AFTER INSERT ON log_topics FOR EACH ROW
+		BEGIN
+			UPDATE log_topics SET position = (SELECT COUNT(*) FROM log_topics p
+				WHERE p.transaction_log_id = NEW.transaction_log_id AND p.deleted_at IS NULL AND p.id < NEW.id)
+			WHERE id = NEW.id;
+		END`,
+	},
+}
+
+// positionBackfills set the position of the topics saved before the migration by dialect,
+// mysql can not select from the updated table in a subquery
+var positionBackfills = map[string]string{
+	"mysql": `UPDATE log_topics t JOIN (
+		SELECT a.id, COUNT(p.id) AS position FROM log_topics a
+		LEFT JOIN log_topics p ON p.transaction_log_id = a.transaction_log_id AND p.deleted_at IS NULL AND p.id < a.id
+		WHERE a.position IS NULL GROUP BY a.id
+	) b ON b.id = t.id SET t.position = b.position`,
+	"postgres": `UPDATE log_topics SET position = (SELECT COUNT(*) FROM log_topics p
+		WHERE p.transaction_log_id = log_topics.transaction_log_id AND p.deleted_at IS NULL AND p.id < log_topics.id)
+		WHERE position IS NULL`,
+	"sqlite": `UPDATE log_topics SET position = (SELECT COUNT(*) FROM log_topics p
+		WHERE p.transaction_log_id = log_topics.transaction_log_id AND p.deleted_at IS NULL AND p.id < log_topics.id)
+		WHERE position IS NULL`,
+}
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "log_topics_position",
+		// eth_getLogs filters topics by position, which is indexed instead of counted per row
+		Up: func(tx *gorm.DB) error {
+			dialect := tx.Dialector.Name()
+			triggers, ok := positionTriggers[dialect]
+			if !ok {
+				return fmt.Errorf("unsupported dialect %s", dialect)
+			}
+			if !tx.Migrator().HasColumn(&types.LogTopic{}, "position") {
+				if err := tx.Exec("ALTER TABLE log_topics ADD COLUMN position int").Error; err != nil {
+					return err
+				}
+			}
+			// 先创建触发器，迁移期间新写入的topic不需要回填
+			for _, stmt := range triggers {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec(positionBackfills[dialect]).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic_position") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_log_topics_topic_position ON log_topics (topic, position, transaction_log_id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			dropTrigger := "DROP TRIGGER IF EXISTS log_topics_position"
+			if tx.Dialector.Name() == "postgres" {
+				dropTrigger += " ON log_topics"
+			}
+			if err := tx.Exec(dropTrigger).Error; err != nil {
+				return err
+			}
+			if tx.Dialector.Name() == "postgres" {
+				if err := tx.Exec("DROP FUNCTION IF EXISTS log_topics_position()").Error; err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic_position") {
+				if err := tx.Migrator().DropIndex(&types.LogTopic{}, "idx_log_topics_topic_position"); err != nil {
+					return err
+				}
+			}
+			return tx.Exec("ALTER TABLE log_topics DROP COLUMN position").Error
+		},
+	})
+}
@@ -0,0 +1,26 @@
+package migration
+
+import (
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "drop_log_topics_topic_index",
+		// idx_log_topics_topic_position covers the topic lookups, scripts/infura.sql no longer creates the old index
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic") {
+				return nil
+			}
+			return tx.Migrator().DropIndex(&types.LogTopic{}, "idx_log_topics_topic")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic") {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX idx_log_topics_topic ON log_topics (topic, transaction_log_id)").Error
+		},
+	})
+}
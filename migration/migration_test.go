@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/okex/exchain/x/infura/types"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "infura.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	m := NewMigrator(newTestDB(t))
+	done, err := m.Up(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(done), len(migrations))
+	}
+	if done, err := m.Up(0); err != nil || len(done) != 0 {
+		t.Fatalf("applied %d migrations again: %v", len(done), err)
+	}
+
+	done, err = m.Down(len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(migrations) {
+		t.Fatalf("rolled back %d migrations, want %d", len(done), len(migrations))
+	}
+	if m.db.Migrator().HasTable(&types.Block{}) {
+		t.Fatal("blocks is not dropped")
+	}
+}
+
+// TestMigratorAdoptsExistingSchema migrates a database created by scripts/infura.sql, which
+// already has the indexes and columns of the later migrations
+func TestMigratorAdoptsExistingSchema(t *testing.T) {
+	m := NewMigrator(newTestDB(t))
+	if _, err := m.Up(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"CREATE INDEX idx_transactions_hash ON transactions (hash)",
+		"ALTER TABLE blocks ADD COLUMN logs_bloom varchar(514)",
+		"ALTER TABLE log_topics ADD COLUMN position int",
+		"CREATE INDEX idx_log_topics_topic_position ON log_topics (topic, position, transaction_log_id)",
+		"CREATE INDEX idx_transaction_receipts_block_hash ON transaction_receipts (block_hash)",
+	} {
+		if err := m.db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("migration %d_%s is not applied", status.Version, status.Name)
+		}
+	}
+	if m.db.Migrator().HasIndex(&types.LogTopic{}, "idx_log_topics_topic") {
+		t.Fatal("idx_log_topics_topic is not dropped")
+	}
+}
+
+func TestMigratorBackfillsTopicPositions(t *testing.T) {
+	m := NewMigrator(newTestDB(t))
+	if _, err := m.Up(5); err != nil {
+		t.Fatal(err)
+	}
+	// two logs whose topics are interleaved by id
+	for _, stmt := range []string{
+		"INSERT INTO transaction_logs (id, address, block_hash, block_number) VALUES (1, '0x1', '0xa', 1), (2, '0x1', '0xa', 1)",
+		"INSERT INTO log_topics (id, topic, transaction_log_id) VALUES (1, 'a', 1), (2, 'b', 2), (3, 'c', 1), (4, 'd', 1), (5, 'e', 2)",
+	} {
+		if err := m.db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	// topics saved after the migration get their position on insert
+	if err := m.db.Exec("INSERT INTO log_topics (id, topic, transaction_log_id) VALUES (6, 'f', 2), (7, 'g', 3)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var positions []struct {
+		Topic    string
+		Position int
+	}
+	if err := m.db.Raw("SELECT topic, position FROM log_topics ORDER BY id").Scan(&positions).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"a": 0, "b": 0, "c": 1, "d": 2, "e": 1, "f": 2, "g": 0}
+	if len(positions) != len(want) {
+		t.Fatalf("got %d topics, want %d", len(positions), len(want))
+	}
+	for _, p := range positions {
+		if p.Position != want[p.Topic] {
+			t.Fatalf("position of %s is %d, want %d", p.Topic, p.Position, want[p.Topic])
+		}
+	}
+}
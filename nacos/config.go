@@ -0,0 +1,56 @@
+package nacos
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+)
+
+// ConfigSource reads the configuration of the rpc service from the nacos config center
+type ConfigSource struct {
+	client config_client.IConfigClient
+	dataID string
+	group  string
+}
+
+func NewConfigSource(urls string, namespace string, dataID string, group string) (*ConfigSource, error) {
+	serverConfigs, err := getServerConfigs(urls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve nacos server url %s: %s", urls, err.Error())
+	}
+	client, err := clients.CreateConfigClient(map[string]interface{}{
+		"serverConfigs": serverConfigs,
+		"clientConfig":  getClientConfig(namespace),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nacos config client. error: %s", err.Error())
+	}
+	return &ConfigSource{
+		client: client,
+		dataID: dataID,
+		group:  group,
+	}, nil
+}
+
+// Get returns the current content of the config
+func (c *ConfigSource) Get() (string, error) {
+	return c.client.GetConfig(vo.ConfigParam{
+		DataId: c.dataID,
+		Group:  c.group,
+	})
+}
+
+// Listen calls onChange with the new content every time the config is published
+func (c *ConfigSource) Listen(onChange func(content string)) error {
+	return c.client.ListenConfig(vo.ConfigParam{
+		DataId: c.dataID,
+		Group:  c.group,
+		OnChange: func(namespace, group, dataId, data string) {
+			log.Info("nacos config changed", "data_id", dataId, "group", group)
+			onChange(data)
+		},
+	})
+}
@@ -0,0 +1,132 @@
+package nacos
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nacos-group/nacos-sdk-go/clients"
+	"github.com/nacos-group/nacos-sdk-go/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/vo"
+)
+
+const clusterName = "DEFAULT"
+
+// Registrar keeps the nacos naming client and manages the instance of the rpc service
+type Registrar struct {
+	client naming_client.INamingClient
+	name   string
+	ip     string
+	port   uint64
+
+	mtx        sync.Mutex
+	weight     float64
+	registered bool
+	closed     bool
+}
+
+// NewRegistrar starts the nacos client of the rpc service
+func NewRegistrar(urls string, namespace string, name string, externalAddr string, weight float64) (*Registrar, error) {
+	ip, port, err := resolveIPAndPort(externalAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s error: %s", externalAddr, err.Error())
+	}
+	serverConfigs, err := getServerConfigs(urls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve nacos server url %s: %s", urls, err.Error())
+	}
+	client, err := clients.CreateNamingClient(map[string]interface{}{
+		"serverConfigs": serverConfigs,
+		"clientConfig":  getClientConfig(namespace),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create nacos client. error: %s", err.Error())
+	}
+	return &Registrar{
+		client: client,
+		name:   name,
+		ip:     ip,
+		port:   uint64(port),
+		weight: weight,
+	}, nil
+}
+
+// Register registers the instance in nacos, it does nothing after Close
+func (r *Registrar) Register() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.closed {
+		return nil
+	}
+	return r.register()
+}
+
+// Deregister removes the instance from nacos so that the gateways stop routing requests to it
+func (r *Registrar) Deregister() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.deregister()
+}
+
+// UpdateWeight changes the weight of the instance, it is applied at once if registered
+func (r *Registrar) UpdateWeight(weight float64) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.weight = weight
+	if !r.registered || r.closed {
+		return nil
+	}
+	return r.register()
+}
+
+// Close deregisters the instance for good, it is called on shutdown
+func (r *Registrar) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.closed = true
+	return r.deregister()
+}
+
+func (r *Registrar) register() error {
+	_, err := r.client.RegisterInstance(vo.RegisterInstanceParam{
+		Ip:          r.ip,
+		Port:        r.port,
+		ServiceName: r.name,
+		Weight:      r.weight,
+		ClusterName: clusterName,
+		Enable:      true,
+		Healthy:     true,
+		Ephemeral:   true,
+		Metadata: map[string]string{
+			"preserved.register.source": "GO",
+			"app_registry_tag":          strconv.FormatInt(time.Now().Unix(), 10),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register instance in nacos server. error: %s", err.Error())
+	}
+	r.registered = true
+	log.Info("registered in nacos", "service", r.name, "ip", r.ip, "port", r.port, "weight", r.weight)
+	return nil
+}
+
+func (r *Registrar) deregister() error {
+	if !r.registered {
+		return nil
+	}
+	_, err := r.client.DeregisterInstance(vo.DeregisterInstanceParam{
+		Ip:          r.ip,
+		Port:        r.port,
+		ServiceName: r.name,
+		Cluster:     clusterName,
+		Ephemeral:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deregister instance in nacos server. error: %s", err.Error())
+	}
+	r.registered = false
+	log.Info("deregistered from nacos", "service", r.name, "ip", r.ip, "port", r.port)
+	return nil
+}
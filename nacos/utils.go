@@ -25,6 +25,17 @@ func getServerConfigs(urls string) ([]constant.ServerConfig, error) {
 	return configs, nil
 }
 
+func getClientConfig(namespace string) constant.ClientConfig {
+	return constant.ClientConfig{
+		TimeoutMs:           5000,
+		ListenInterval:      10000,
+		NotLoadCacheAtStart: true,
+		NamespaceId:         namespace,
+		LogDir:              "/dev/null",
+		LogLevel:            "error",
+	}
+}
+
 func resolveIPAndPort(addr string) (string, int, error) {
 	lAddr := strings.Split(addr, ":")
 	ip := lAddr[0]
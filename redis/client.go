@@ -2,26 +2,259 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/metrics"
+	"github.com/okex/infura-service/tracing"
 )
 
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Config is the connection config of redis, the password is masked when it is printed
+type Config struct {
+	Mode       string   // standalone, sentinel or cluster
+	Addrs      []string // host:port of the server, the sentinels or the cluster seed nodes
+	MasterName string   // sentinel only
+	Password   string
+	DB         int // not supported by cluster
+	TLS        TLSConfig
+	Pool       PoolConfig
+	// SentinelPassword is the password of the sentinels, which may differ from the one of the master
+	SentinelPassword string
+}
+
+// TLSConfig enables tls with the system root cas if CAFile is empty
+type TLSConfig struct {
+	Enabled    bool
+	CAFile     string
+	ServerName string // defaults to the host of the address
+}
+
+// PoolConfig is the connection pool of each redis node, 0 for the defaults of go-redis
+type PoolConfig struct {
+	PoolSize     int
+	MinIdleConns int
+	PoolTimeout  time.Duration
+	IdleTimeout  time.Duration
+}
+
+func (c Config) String() string {
+	if c.Password != "" {
+		c.Password = "******"
+	}
+	if c.SentinelPassword != "" {
+		c.SentinelPassword = "******"
+	}
+	type config Config // no String method
+	return fmt.Sprintf("%+v", config(c))
+}
+
+func (c Config) GoString() string {
+	return c.String()
+}
+
+// Nil is the error of a missing key
+const Nil = redis.Nil
+
+// Cmdable is the redis commands used by the service, implemented by Client
+type Cmdable interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string, expiration time.Duration) error
+	Del(ctx context.Context, key string) (bool, error)
+	Incr(ctx context.Context, key string) (int64, error)
+	Ping(ctx context.Context) error
+	TakeTokens(ctx context.Context, key string, rate float64, burst int, cost int) (time.Duration, error)
+	CompareAndSwap(ctx context.Context, key string, old string, value string, expiration time.Duration) (bool, error)
+}
+
+var _ Cmdable = (*Client)(nil)
+
+// Client is the redis client of the service, the commands are the same whether
+// redis runs standalone, under sentinel or as a cluster
 type Client struct {
-	redis *redis.Client
+	redis redis.UniversalClient
 }
 
-func NewClient(url string, pass string, db int) *Client {
-	redisCli := redis.NewClient(&redis.Options{
-		Addr:     url,
-		Password: pass, // no password set
-		DB:       db,   // use select DB
-	})
+func NewClient(config Config) (*Client, error) {
+	if len(config.Addrs) == 0 {
+		return nil, errors.New("no redis address")
+	}
+	opts := &redis.UniversalOptions{
+		Addrs:        config.Addrs,
+		Password:     config.Password,
+		DB:           config.DB,
+		PoolSize:     config.Pool.PoolSize,
+		MinIdleConns: config.Pool.MinIdleConns,
+		PoolTimeout:  config.Pool.PoolTimeout,
+		IdleTimeout:  config.Pool.IdleTimeout,
+	}
+	if config.TLS.Enabled {
+		tlsConfig, err := config.TLS.load()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	var redisCli redis.UniversalClient
+	switch config.Mode {
+	case ModeStandalone, "":
+		if len(config.Addrs) > 1 {
+			return nil, errors.New("standalone redis takes a single address")
+		}
+		redisCli = redis.NewClient(opts.Simple())
+	case ModeSentinel:
+		if config.MasterName == "" {
+			return nil, errors.New("sentinel redis requires the master name")
+		}
+		opts.MasterName = config.MasterName
+		opts.SentinelPassword = config.SentinelPassword
+		redisCli = redis.NewFailoverClient(opts.Failover())
+	case ModeCluster:
+		if config.DB != 0 {
+			return nil, errors.New("cluster redis only has db 0")
+		}
+		redisCli = redis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, fmt.Errorf("unsupported redis mode %s", config.Mode)
+	}
+	redisCli.AddHook(tracing.Redis())
 	return &Client{
 		redis: redisCli,
+	}, nil
+}
+
+func (c TLSConfig) load() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
 	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", c.CAFile)
+		}
+	}
+	return tlsConfig, nil
 }
 
-func (c *Client) Get(key string) (string, error) {
-	value, err := c.redis.Get(context.Background(), key).Result()
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	value, err := c.redis.Get(ctx, key).Result()
+	observe(ctx, "get", start, err)
 	return value, err
 }
+
+func (c *Client) Set(ctx context.Context, key string, value string, expiration time.Duration) error {
+	start := time.Now()
+	err := c.redis.Set(ctx, key, value, expiration).Err()
+	observe(ctx, "set", start, err)
+	return err
+}
+
+func (c *Client) Del(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	n, err := c.redis.Del(ctx, key).Result()
+	observe(ctx, "del", start, err)
+	return n > 0, err
+}
+
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	start := time.Now()
+	n, err := c.redis.Incr(ctx, key).Result()
+	observe(ctx, "incr", start, err)
+	return n, err
+}
+
+// compareAndSwapScript sets the key only if its value is still ARGV[1]
+var compareAndSwapScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// CompareAndSwap sets the key to value with the expiration if its value is still old, it
+// returns false if the key was changed or removed by another client
+func (c *Client) CompareAndSwap(ctx context.Context, key string, old string, value string, expiration time.Duration) (bool, error) {
+	start := time.Now()
+	swapped, err := compareAndSwapScript.Run(ctx, c.redis, []string{key},
+		old, value, expiration.Milliseconds()).Int()
+	observe(ctx, "evalsha", start, err)
+	return swapped == 1, err
+}
+
+// observe records the command, a missing key is not an error. The failures are logged with the
+// request id, except those of the requests canceled by the caller.
+func observe(ctx context.Context, command string, start time.Time, err error) {
+	if err == redis.Nil {
+		err = nil
+	}
+	if err != nil {
+		l := logger.FromContext(ctx)
+		if errors.Is(err, context.Canceled) {
+			l.Debug("redis command canceled", "command", command, "err", err)
+		} else {
+			l.Warn("redis command failed", "command", command, "elapsed", time.Since(start), "err", err)
+		}
+	}
+	metrics.ObserveRedis(command, start, err)
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := c.redis.Ping(ctx).Err()
+	observe(ctx, "ping", start, err)
+	return err
+}
+
+// tokenBucketScript refills the bucket by the elapsed time and takes the tokens,
+// it returns the milliseconds to wait when there are not enough tokens. The time is read
+// from redis as the clocks of the replicas may drift
+var tokenBucketScript = redis.NewScript(`
+-- redis before 5 only replicates the effects of a script calling TIME with this
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local wait = 0
+if tokens >= cost then
+	tokens = tokens - cost
+else
+	wait = math.ceil((cost - tokens) / rate * 1000)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return wait
+`)
+
+// TakeTokens takes cost tokens from the bucket of key shared by all replicas, it returns
+// the time to wait if the tokens are not enough
+func (c *Client) TakeTokens(ctx context.Context, key string, rate float64, burst int, cost int) (time.Duration, error) {
+	start := time.Now()
+	wait, err := tokenBucketScript.Run(ctx, c.redis, []string{key}, rate, burst, cost).Int64()
+	observe(ctx, "evalsha", start, err)
+	return time.Duration(wait) * time.Millisecond, err
+}
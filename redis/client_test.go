@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestClient(t *testing.T) (*Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	cli, err := NewClient(Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, mr
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+
+	if err := cli.Set(ctx, "key", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	swapped, err := cli.CompareAndSwap(ctx, "key", "a", "b", time.Minute)
+	if err != nil || !swapped {
+		t.Fatalf("swap a to b: %v %v", swapped, err)
+	}
+	// the second poller read a as well and loses
+	swapped, err = cli.CompareAndSwap(ctx, "key", "a", "c", time.Minute)
+	if err != nil || swapped {
+		t.Fatalf("swap stale a to c: %v %v", swapped, err)
+	}
+	if value, _ := cli.Get(ctx, "key"); value != "b" {
+		t.Fatalf("value %q, want b", value)
+	}
+	if ttl := mr.TTL("key"); ttl != time.Minute {
+		t.Fatalf("ttl %v, want 1m", ttl)
+	}
+
+	// a removed key is not recreated
+	cli.Del(ctx, "key")
+	swapped, err = cli.CompareAndSwap(ctx, "key", "b", "d", time.Minute)
+	if err != nil || swapped {
+		t.Fatalf("swap removed key: %v %v", swapped, err)
+	}
+	if _, err := cli.Get(ctx, "key"); err != Nil {
+		t.Fatalf("get removed key: %v, want Nil", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	var redisCli Cmdable = cli
+
+	if err := redisCli.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redisCli.Get(ctx, "key"); err != Nil {
+		t.Fatalf("get missing key: %v, want Nil", err)
+	}
+	if err := redisCli.Set(ctx, "key", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := redisCli.Get(ctx, "key"); err != nil || value != "a" {
+		t.Fatalf("value %q %v, want a", value, err)
+	}
+	if deleted, err := redisCli.Del(ctx, "key"); err != nil || !deleted {
+		t.Fatalf("del: %v %v", deleted, err)
+	}
+	if deleted, err := redisCli.Del(ctx, "key"); err != nil || deleted {
+		t.Fatalf("del missing key: %v %v", deleted, err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		if n, err := redisCli.Incr(ctx, "counter"); err != nil || n != i {
+			t.Fatalf("incr %d %v, want %d", n, err, i)
+		}
+	}
+}
+
+func TestTakeTokens(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	now := time.Now()
+	mr.SetTime(now)
+
+	// a full bucket of 2 tokens refilled at 1 token per second
+	if wait, err := cli.TakeTokens(ctx, "bucket", 1, 2, 2); err != nil || wait != 0 {
+		t.Fatalf("take the burst: wait %v %v", wait, err)
+	}
+	wait, err := cli.TakeTokens(ctx, "bucket", 1, 2, 1)
+	if err != nil || wait <= 0 || wait > time.Second {
+		t.Fatalf("take from the empty bucket: wait %v %v, want up to 1s", wait, err)
+	}
+	// the bucket is refilled by the clock of redis
+	mr.SetTime(now.Add(time.Second))
+	if wait, err := cli.TakeTokens(ctx, "bucket", 1, 2, 1); err != nil || wait != 0 {
+		t.Fatalf("take after a second of redis: wait %v %v", wait, err)
+	}
+	if ttl := mr.TTL("bucket"); ttl <= 0 {
+		t.Fatalf("bucket ttl %v, want it to expire", ttl)
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	config := Config{Mode: ModeSentinel, MasterName: "master", Password: "secret", SentinelPassword: "sentinel-secret"}
+	if s := config.String(); strings.Contains(s, "secret") {
+		t.Fatalf("config %s shows the passwords", s)
+	}
+	if s := fmt.Sprintf("%#v", config); strings.Contains(s, "secret") {
+		t.Fatalf("config %s shows the passwords", s)
+	}
+}
@@ -1,31 +1,33 @@
 package rpc
 
 import (
-	"log"
-
-	"github.com/okex/infura-service/redis"
-
-	"github.com/okex/infura-service/mysql"
-
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/okex/infura-service/redis"
 	"github.com/okex/infura-service/rpc/namespaces/eth"
+	"github.com/okex/infura-service/rpc/namespaces/net"
+	"github.com/okex/infura-service/rpc/namespaces/web3"
+	"github.com/okex/infura-service/store"
 )
 
 const (
-	ethNamespace = "eth"
-	apiVersion   = "1.0"
+	ethNamespace  = "eth"
+	netNamespace  = "net"
+	web3Namespace = "web3"
+	apiVersion    = "1.0"
 )
 
 // getAPIs returns the list of all APIs from the Ethereum namespaces
-func getAPIs(config *Config) []rpc.API {
-	orm, err := mysql.NewOrm(config.MysqlUrl, config.MysqlUser, config.MysqlPass, config.MysqlDB)
-	if err != nil {
-		log.Fatal(err)
-	}
-	redisCli := redis.NewClient(config.RedisUrl, config.RedisAuth, config.RedisDB)
-	ethAPI, err := eth.NewAPI(orm, redisCli)
+func getAPIs(config *Config, orm store.Backend, redisCli redis.Cmdable) []rpc.API {
+	ethAPI, err := eth.NewAPI(orm, redisCli, eth.Config{
+		ChainID:          config.ChainID,
+		PersistLogsBloom: config.PersistLogsBloom,
+		MaxLogs:          config.MaxLogs,
+		MaxBlockRange:    config.MaxBlockRange,
+		FinalityDepth:    config.FinalityDepth,
+	})
 	if err != nil {
-		log.Fatal(err)
+		log.Crit("failed to create eth api", "err", err)
 	}
 	apis := []rpc.API{
 		{
@@ -34,6 +36,18 @@ func getAPIs(config *Config) []rpc.API {
 			Service:   ethAPI,
 			Public:    true,
 		},
+		{
+			Namespace: netNamespace,
+			Version:   apiVersion,
+			Service:   net.NewAPI(config.ChainID),
+			Public:    true,
+		},
+		{
+			Namespace: web3Namespace,
+			Version:   apiVersion,
+			Service:   web3.NewAPI(apiVersion),
+			Public:    true,
+		},
 	}
 	return apis
 }
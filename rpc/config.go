@@ -1,25 +1,291 @@
 package rpc
 
-import "errors"
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+)
+
+const (
+	ProfileDev  = "dev"
+	ProfileProd = "prod"
+
+	// DefaultMysqlPass is the password of the dev databases, it is only allowed in the dev profile
+	DefaultMysqlPass = "root"
+)
+
+// postgresSSLModes are the sslmodes of libpq
+var postgresSSLModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true, "require": true, "verify-ca": true, "verify-full": true,
+}
 
 type Config struct {
-	Address          string
-	NacosUrl         string
-	NacosNamespaceId string
-	NacosServiceName string
-	NacosServiceAddr string
-	MysqlUrl         string
-	MysqlUser        string
-	MysqlPass        string
-	MysqlDB          string
-	RedisUrl         string
-	RedisAuth        string
-	RedisDB          int
-}
-
-func validateConfig(config *Config) error {
-	if config.MysqlUser == "" || config.MysqlUser == "" {
-		return errors.New("must set mysql url or user")
+	Profile            string // dev or prod
+	Address            string
+	NacosUrl           string
+	NacosNamespaceId   string
+	NacosServiceName   string
+	NacosServiceAddr   string
+	NacosWeight        float64
+	DBDriver           string
+	MysqlUrl           string
+	MysqlUser          string
+	MysqlPass          string
+	MysqlDB            string
+	PostgresSSLMode    string
+	MysqlReplicas      string // comma separated host:port of the read replicas
+	MysqlReplicaMaxLag int64  // blocks a replica may be behind the primary, 0 to disable
+	MysqlMaxOpenConns  int
+	MysqlMaxIdleConns  int
+	MysqlConnLifetime  time.Duration
+	MysqlConnIdleTime  time.Duration
+	RedisMode          string // standalone, sentinel or cluster
+	RedisUrl           string // comma separated host:port of the sentinels or the cluster nodes
+	RedisMasterName    string
+	RedisAuth          string
+	RedisSentinelAuth  string
+	RedisDB            int
+	RedisTLS           bool
+	RedisTLSCAFile     string
+	RedisTLSServerName string
+	RedisPoolSize      int
+	RedisMinIdleConns  int
+	RedisPoolTimeout   time.Duration
+	RedisIdleTimeout   time.Duration
+	ChainID            int64
+	UpstreamUrl        string
+	PersistLogsBloom   bool
+	MaxLogs            int
+	MaxBlockRange      int64
+	FinalityDepth      int64
+	CacheSize          int
+	CacheTTL           time.Duration
+	MaxIndexerLag      time.Duration
+	ShutdownDelay      time.Duration
+	ShutdownTimeout    time.Duration
+	RateLimit          float64 // requests per second of a caller, 0 for unlimited
+	RateLimitLogs      float64 // requests per second of a caller for eth_getLogs and filter logs
+	RateLimitRedis     bool
+	APIKeys            string // comma separated
+	APIKeyRequired     bool
+	TrustedProxies     string // comma separated ips or cidrs whose X-Forwarded-For is trusted, empty to trust none
+	WSOrigins          string // comma separated origins allowed to open websockets, * for any
+	LogLevel           string
+	LogFormat          string
+	SlowQueryThreshold time.Duration // 0 to disable
+	TraceEndpoint      string        // otlp http endpoint, empty to disable
+	TraceSampleRatio   float64
+}
+
+// DBConfig returns the connection config of the database
+func (config *Config) DBConfig() store.Config {
+	return store.Config{
+		Addr:          config.MysqlUrl,
+		User:          config.MysqlUser,
+		Password:      config.MysqlPass,
+		DBName:        config.MysqlDB,
+		SSLMode:       config.PostgresSSLMode,
+		Replicas:      splitList(config.MysqlReplicas),
+		ReplicaMaxLag: config.MysqlReplicaMaxLag,
+		Pool: store.PoolConfig{
+			MaxOpenConns:    config.MysqlMaxOpenConns,
+			MaxIdleConns:    config.MysqlMaxIdleConns,
+			ConnMaxLifetime: config.MysqlConnLifetime,
+			ConnMaxIdleTime: config.MysqlConnIdleTime,
+		},
+	}
+}
+
+// RedisConfig returns the connection config of redis
+func (config *Config) RedisConfig() redis.Config {
+	return redis.Config{
+		Mode:             config.RedisMode,
+		Addrs:            splitList(config.RedisUrl),
+		MasterName:       config.RedisMasterName,
+		Password:         config.RedisAuth,
+		SentinelPassword: config.RedisSentinelAuth,
+		DB:               config.RedisDB,
+		TLS: redis.TLSConfig{
+			Enabled:    config.RedisTLS,
+			CAFile:     config.RedisTLSCAFile,
+			ServerName: config.RedisTLSServerName,
+		},
+		Pool: redis.PoolConfig{
+			PoolSize:     config.RedisPoolSize,
+			MinIdleConns: config.RedisMinIdleConns,
+			PoolTimeout:  config.RedisPoolTimeout,
+			IdleTimeout:  config.RedisIdleTimeout,
+		},
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ValidateMysqlPass refuses an empty or the default password of mysql and postgres outside the
+// dev profile, the migrate command checks it too
+func ValidateMysqlPass(profile string, pass string) error {
+	if profile == ProfileDev || (pass != "" && pass != DefaultMysqlPass) {
+		return nil
+	}
+	return fmt.Errorf("an empty or the default password is only allowed in the %s profile", ProfileDev)
+}
+
+// ConfigError lists every invalid field of the config
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid config: " + strings.Join(e, "; ")
+}
+
+// ValidateConfig checks all fields of the config, the fields are named by their flags
+func ValidateConfig(config *Config) error {
+	var errs ConfigError
+	check := func(ok bool, field string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	checkAddr := func(field string, addr string) {
+		if err := validateHostPort(addr); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", field, err.Error()))
+		}
+	}
+	checkURL := func(field string, rawURL string, schemes ...string) {
+		if err := validateURL(rawURL, schemes); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", field, err.Error()))
+		}
+	}
+
+	check(config.Profile == ProfileDev || config.Profile == ProfileProd, "profile", "must be %s or %s", ProfileDev, ProfileProd)
+	checkAddr("address", config.Address)
+	if config.NacosUrl != "" {
+		for _, addr := range strings.Split(config.NacosUrl, ",") {
+			checkAddr("nacos_url", addr)
+		}
+		check(config.NacosServiceName != "", "nacos-service-name", "must be set to register in nacos")
+		checkAddr("nacos_service_address", config.NacosServiceAddr)
+		check(config.NacosWeight >= 0, "nacos-weight", "must not be negative")
+	}
+	switch config.DBDriver {
+	case store.DriverSQLite:
+		check(config.MysqlDB != "", "mysql-db", "must set sqlite db path")
+		check(config.MysqlReplicas == "", "mysql-replicas", "not supported by sqlite")
+	case store.DriverMySQL, store.DriverPostgres:
+		checkAddr("mysql-url", config.MysqlUrl)
+		check(config.MysqlUser != "", "mysql-user", "must be set")
+		check(config.MysqlDB != "", "mysql-db", "must be set")
+		if err := ValidateMysqlPass(config.Profile, config.MysqlPass); err != nil {
+			errs = append(errs, "mysql-pass: "+err.Error())
+		}
+		for _, addr := range splitList(config.MysqlReplicas) {
+			checkAddr("mysql-replicas", addr)
+		}
+		if config.DBDriver == store.DriverPostgres {
+			check(postgresSSLModes[config.PostgresSSLMode], "postgres-sslmode", "unsupported sslmode %q", config.PostgresSSLMode)
+		}
+	default:
+		check(false, "db-driver", "unsupported db driver %q", config.DBDriver)
+	}
+	check(config.MysqlReplicaMaxLag >= 0, "mysql-replica-max-lag", "must not be negative")
+	check(config.MysqlMaxOpenConns >= 0, "mysql-max-open-conns", "must not be negative")
+	check(config.MysqlMaxIdleConns >= 0, "mysql-max-idle-conns", "must not be negative")
+	check(config.MysqlConnLifetime >= 0, "mysql-conn-max-lifetime", "must not be negative")
+	check(config.MysqlConnIdleTime >= 0, "mysql-conn-max-idle-time", "must not be negative")
+	redisAddrs := splitList(config.RedisUrl)
+	for _, addr := range redisAddrs {
+		checkAddr("redis-url", addr)
+	}
+	switch config.RedisMode {
+	case redis.ModeStandalone:
+		check(len(redisAddrs) == 1, "redis-url", "must be a single address in %s mode", config.RedisMode)
+	case redis.ModeSentinel:
+		check(len(redisAddrs) > 0, "redis-url", "must be set")
+		check(config.RedisMasterName != "", "redis-master-name", "must be set in %s mode", config.RedisMode)
+	case redis.ModeCluster:
+		check(len(redisAddrs) > 0, "redis-url", "must be set")
+		check(config.RedisDB == 0, "redis-db", "must be 0 in %s mode", config.RedisMode)
+	default:
+		check(false, "redis-mode", "must be %s, %s or %s", redis.ModeStandalone, redis.ModeSentinel, redis.ModeCluster)
+	}
+	check(config.RedisMode == redis.ModeSentinel || config.RedisSentinelAuth == "", "redis-sentinel-auth", "requires %s mode", redis.ModeSentinel)
+	check(config.RedisDB >= 0, "redis-db", "must not be negative")
+	check(config.RedisTLS || config.RedisTLSCAFile == "", "redis-tls-ca-file", "requires redis-tls")
+	check(config.RedisPoolSize >= 0, "redis-pool-size", "must not be negative")
+	check(config.RedisMinIdleConns >= 0, "redis-min-idle-conns", "must not be negative")
+	check(config.RedisPoolTimeout >= 0, "redis-pool-timeout", "must not be negative")
+	check(config.RedisIdleTimeout >= 0, "redis-idle-timeout", "must not be negative")
+	check(config.ChainID > 0, "chain-id", "must be positive")
+	if config.UpstreamUrl != "" {
+		checkURL("upstream-url", config.UpstreamUrl, "http", "https")
+	}
+	check(config.MaxLogs >= 0, "max-logs", "must not be negative")
+	check(config.MaxBlockRange >= 0, "max-block-range", "must not be negative")
+	check(config.FinalityDepth >= 0, "finality-depth", "must not be negative")
+	check(config.CacheSize >= 0, "cache-size", "must not be negative")
+	check(config.CacheTTL >= 0, "cache-ttl", "must not be negative")
+	check(config.MaxIndexerLag >= 0, "max-indexer-lag", "must not be negative")
+	check(config.ShutdownDelay >= 0, "shutdown-delay", "must not be negative")
+	check(config.ShutdownTimeout >= 0, "shutdown-timeout", "must not be negative")
+	check(config.RateLimit >= 0, "rate-limit", "must not be negative")
+	check(config.RateLimitLogs >= 0, "rate-limit-logs", "must not be negative")
+	check(!config.APIKeyRequired || config.APIKeys != "", "api-keys", "must be set if api key is required")
+	for _, proxy := range splitList(config.TrustedProxies) {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "trusted-proxies", "%q is not an ip or cidr", proxy)
+	}
+	if _, err := log.LvlFromString(config.LogLevel); err != nil {
+		check(false, "log-level", "unknown level %q", config.LogLevel)
+	}
+	check(config.LogFormat == logger.FormatJSON || config.LogFormat == logger.FormatTerminal,
+		"log-format", "unsupported format %q", config.LogFormat)
+	check(config.SlowQueryThreshold >= 0, "slow-query-threshold", "must not be negative")
+	if config.TraceEndpoint != "" {
+		checkURL("trace-endpoint", config.TraceEndpoint, "http", "https")
+	}
+	check(config.TraceSampleRatio >= 0 && config.TraceSampleRatio <= 1, "trace-sample-ratio", "must be within [0, 1]")
+
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
+
+func validateHostPort(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not host:port", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func validateURL(rawURL string, schemes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme && u.Host != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not a %s url", rawURL, strings.Join(schemes, " or "))
+}
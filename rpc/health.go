@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"github.com/okex/infura-service/nacos"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+)
+
+const readinessInterval = 10 * time.Second
+
+// healthPingTimeout is the timeout of each dependency, so that readyz returns if the database or redis hangs
+var healthPingTimeout = 2 * time.Second
+
+// healthChecker checks the dependencies required to serve requests
+type healthChecker struct {
+	orm      store.Backend
+	redisCli redis.Cmdable
+	lag      func(ctx context.Context) (float64, error)
+	maxLag   int64 // time.Duration, 0 to disable the indexer check
+	// shuttingDown is set on shutdown, so that the service is not ready while draining
+	shuttingDown int32
+}
+
+func newHealthChecker(orm store.Backend, redisCli redis.Cmdable, maxLag time.Duration) *healthChecker {
+	return &healthChecker{
+		orm:      orm,
+		redisCli: redisCli,
+		lag:      indexerLag(orm, redisCli),
+		maxLag:   int64(maxLag),
+	}
+}
+
+func (h *healthChecker) setMaxLag(maxLag time.Duration) {
+	atomic.StoreInt64(&h.maxLag, int64(maxLag))
+}
+
+// check returns the error of every failed dependency, empty if the service is ready
+func (h *healthChecker) check(ctx context.Context) map[string]string {
+	failures := make(map[string]string)
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		failures["service"] = "shutting down"
+		return failures
+	}
+	withTimeout := func(check func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+		defer cancel()
+		return check(ctx)
+	}
+	if err := withTimeout(h.orm.Ping); err != nil {
+		failures["mysql"] = err.Error()
+	}
+	if err := withTimeout(h.redisCli.Ping); err != nil {
+		failures["redis"] = err.Error()
+	}
+	if maxLag := time.Duration(atomic.LoadInt64(&h.maxLag)); maxLag > 0 {
+		var seconds float64
+		err := withTimeout(func(ctx context.Context) (err error) {
+			seconds, err = h.lag(ctx)
+			return err
+		})
+		if err != nil {
+			failures["indexer"] = err.Error()
+		} else if lag := time.Duration(seconds) * time.Second; lag > maxLag {
+			failures["indexer"] = fmt.Sprintf("latest indexed block is %s behind, max lag is %s", lag, maxLag)
+		}
+	}
+	return failures
+}
+
+func (h *healthChecker) setShuttingDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func (h *healthChecker) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *healthChecker) readyz(c *gin.Context) {
+	failures := h.check(c.Request.Context())
+	if len(failures) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "errors": failures})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// watchReadiness deregisters the instance from nacos while the service is not ready,
+// and registers it again once it recovers
+func (s *Service) watchReadiness(registrar *nacos.Registrar, stop <-chan struct{}) {
+	ticker := time.NewTicker(readinessInterval)
+	defer ticker.Stop()
+
+	ready := true
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		failures := s.health.check(context.Background())
+		if (len(failures) == 0) == ready {
+			continue
+		}
+		ready = len(failures) == 0
+		var err error
+		if ready {
+			err = registrar.Register()
+		} else {
+			log.Warn("service is not ready", "failures", failures)
+			err = registrar.Deregister()
+		}
+		if err != nil {
+			log.Error("failed to update nacos registration", "ready", ready, "err", err)
+			ready = !ready
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/okex/infura-service/redis"
+)
+
+// hangingBackend is a database that does not answer the pings
+type hangingBackend struct {
+	blockBackend
+}
+
+func (b *hangingBackend) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHealthCheckFailsFast(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health := newHealthChecker(&hangingBackend{}, redisCli, time.Minute)
+	defer func(timeout time.Duration) { healthPingTimeout = timeout }(healthPingTimeout)
+	healthPingTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	failures := health.check(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("check took %s on a hanging database", elapsed)
+	}
+	if failures["mysql"] == "" {
+		t.Fatalf("failures %v, want the hanging database", failures)
+	}
+	// the indexer state is unknown without a task
+	if !strings.Contains(failures["indexer"], "no task") {
+		t.Fatalf("failures %v, want the missing indexer task", failures)
+	}
+	if _, ok := failures["redis"]; ok {
+		t.Fatalf("failures %v, want redis healthy", failures)
+	}
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okex/infura-service/logger"
+)
+
+const (
+	requestIDHeader    = "X-Request-Id"
+	maxRequestIDLength = 64
+)
+
+// requestID takes the request id from the header or generates one, it is echoed in the
+// response and carried by the request context down to the sql logs
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = logger.NewRequestID()
+		}
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// accessLog logs every request with the json-rpc methods. The route is logged instead of the
+// path, which may contain the api key. Metrics and health checks are logged at debug level.
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		var methods []string
+		if c.Request.Method == http.MethodPost {
+			reqs, _, _ := requestMessages(c)
+			for _, req := range reqs {
+				methods = append(methods, req.Method)
+			}
+		}
+		c.Next()
+
+		l := logger.FromContext(c.Request.Context())
+		write := l.Info
+		switch c.FullPath() {
+		case "/metrics", "/healthz", "/readyz":
+			write = l.Debug
+		}
+		ctx := []interface{}{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"elapsed", time.Since(start),
+			"ip", c.ClientIP(),
+		}
+		if len(methods) > 0 {
+			ctx = append(ctx, "rpc_methods", strings.Join(methods, ","))
+		}
+		if len(c.Errors) > 0 {
+			ctx = append(ctx, "err", c.Errors.String())
+		}
+		write("request", ctx...)
+	}
+}
+
+// recovery logs the panics of the handlers and responds 500
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		logger.FromContext(c.Request.Context()).Error("handler panicked", "err", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
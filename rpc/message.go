@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxRequestContentLength = 1024 * 1024 * 5
+	messagesContextKey      = "jsonrpc_messages"
+)
+
+var null = json.RawMessage("null")
+
+type jsonError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Error   *jsonError      `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+func (msg *jsonrpcMessage) isNotification() bool {
+	return len(msg.ID) == 0
+}
+
+func newErrorMessage(id json.RawMessage, code int, message string, data interface{}) *jsonrpcMessage {
+	return &jsonrpcMessage{
+		Version: "2.0",
+		ID:      id,
+		Error: &jsonError{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
+
+func parseMessages(raw []byte) ([]*jsonrpcMessage, bool, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var msgs []*jsonrpcMessage
+		err := json.Unmarshal(raw, &msgs)
+		return msgs, true, err
+	}
+	msg := &jsonrpcMessage{}
+	err := json.Unmarshal(raw, msg)
+	return []*jsonrpcMessage{msg}, false, err
+}
+
+// requestMessages parses the json-rpc calls of the request once for all middlewares,
+// the request body is kept for the rpc server
+func requestMessages(c *gin.Context) (msgs []*jsonrpcMessage, batch bool, ok bool) {
+	type parsed struct {
+		msgs  []*jsonrpcMessage
+		batch bool
+		ok    bool
+	}
+	if value, exists := c.Get(messagesContextKey); exists {
+		p := value.(parsed)
+		return p.msgs, p.batch, p.ok
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxRequestContentLength))
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err == nil {
+		msgs, batch, err = parseMessages(body)
+	}
+	ok = err == nil && len(msgs) > 0
+	c.Set(messagesContextKey, parsed{msgs: msgs, batch: batch, ok: ok})
+	return msgs, batch, ok
+}
+
+// writeMessages writes the responses in the same form of the request
+func writeMessages(c *gin.Context, status int, msgs []*jsonrpcMessage, batch bool) {
+	if batch {
+		c.JSON(status, msgs)
+	} else if len(msgs) > 0 {
+		c.JSON(status, msgs[0])
+	} else {
+		c.Status(status)
+	}
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/okex/infura-service/metrics"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/rpc/namespaces/eth"
+	"github.com/okex/infura-service/store"
+)
+
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// rpcMetrics records the count, errors and latency of every json-rpc method in the request, the
+// latency of a batch is recorded as a whole
+func rpcMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqs, batch, ok := requestMessages(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		start := time.Now()
+		c.Next()
+		duration := time.Since(start)
+
+		failed := make(map[string]bool)
+		resps, _, _ := parseMessages(recorder.body.Bytes())
+		for _, resp := range resps {
+			if resp.Error != nil {
+				failed[string(resp.ID)] = true
+			}
+		}
+		if !batch {
+			metrics.ObserveRPC(reqs[0].Method, duration, failed[string(reqs[0].ID)])
+			return
+		}
+		methods := make([]string, len(reqs))
+		callFailed := make([]bool, len(reqs))
+		for i, req := range reqs {
+			methods[i] = req.Method
+			callFailed[i] = failed[string(req.ID)]
+		}
+		metrics.ObserveRPCBatch(methods, callFailed, duration)
+	}
+}
+
+// latestBlock returns the latest height of the infura indexer
+func latestBlock(redisCli redis.Cmdable) func() (int64, error) {
+	return func() (int64, error) {
+		task, err := eth.LatestTask(context.Background(), redisCli)
+		if err != nil {
+			return 0, err
+		}
+		return task.Height, nil
+	}
+}
+
+// indexerLag returns the seconds since the latest task of the infura indexer, the timestamp
+// of the latest block is used if the task has no update time. The block is read once per
+// height, not on every scrape. It fails if the task or its block can not be read.
+func indexerLag(orm store.Backend, redisCli redis.Cmdable) func(ctx context.Context) (float64, error) {
+	var (
+		mtx       sync.Mutex
+		height    int64
+		timestamp int64
+	)
+	return func(ctx context.Context) (float64, error) {
+		task, err := eth.LatestTask(ctx, redisCli)
+		if err == redis.Nil {
+			return 0, errors.New("no task of the infura indexer")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read the task of the infura indexer: %w", err)
+		}
+		updatedAt := task.UpdatedAt
+		if updatedAt == 0 {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if task.Height != height {
+				block, err := orm.GetBlockByNumber(ctx, task.Height)
+				if err != nil {
+					return 0, fmt.Errorf("failed to read the latest indexed block %d: %w", task.Height, err)
+				}
+				height, timestamp = task.Height, int64(block.Timestamp)
+			}
+			updatedAt = timestamp
+		}
+		return float64(time.Now().Unix() - updatedAt), nil
+	}
+}
+
+// indexerLagGauge returns the indexer lag of the gauge, NaN if it is unknown
+func indexerLagGauge(lag func(ctx context.Context) (float64, error)) func() float64 {
+	return func() float64 {
+		seconds, err := lag(context.Background())
+		if err != nil {
+			return math.NaN()
+		}
+		return seconds
+	}
+}
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+)
+
+// blockBackend serves blocks with the timestamp of their height and counts the reads
+type blockBackend struct {
+	store.Backend
+	reads int
+}
+
+func (b *blockBackend) GetBlockByNumber(ctx context.Context, blockNum int64) (types.Block, error) {
+	b.reads++
+	return types.Block{Number: blockNum, Timestamp: uint64(blockNum)}, nil
+}
+
+func TestIndexerLagReadsBlockOncePerHeight(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &blockBackend{}
+	lag := indexerLag(backend, redisCli)
+
+	now := time.Now().Unix()
+	mr.Set("infura_latest_task", fmt.Sprintf(`{"height":%d}`, now-10))
+	for i := 0; i < 3; i++ {
+		if seconds, err := lag(context.Background()); err != nil || seconds < 10 || seconds > 12 {
+			t.Fatalf("lag %v %v, want 10s", seconds, err)
+		}
+	}
+	if backend.reads != 1 {
+		t.Fatalf("block read %d times for one height, want once", backend.reads)
+	}
+	mr.Set("infura_latest_task", fmt.Sprintf(`{"height":%d}`, now-5))
+	if seconds, err := lag(context.Background()); err != nil || seconds < 5 || seconds > 7 {
+		t.Fatalf("lag %v %v, want 5s", seconds, err)
+	}
+	if backend.reads != 2 {
+		t.Fatalf("block read %d times for two heights, want twice", backend.reads)
+	}
+}
@@ -2,41 +2,70 @@ package eth
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
 	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/eth/filters"
-	"github.com/ethereum/go-ethereum/log"
-	"github.com/ethereum/go-ethereum/rpc"
 	evmtypes "github.com/okex/exchain/x/evm/watcher"
-	"github.com/okex/exchain/x/infura"
 	"github.com/okex/exchain/x/infura/types"
-	"github.com/okex/infura-service/mysql"
+	"github.com/okex/infura-service/logger"
 	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+	"github.com/okex/infura-service/tracing"
+	"gorm.io/gorm"
 )
 
 type PublicAPI struct {
-	orm      *mysql.Orm
-	redisCli *redis.Client
+	orm      store.Backend
+	redisCli redis.Cmdable
+	config   Config
+	chainID  *big.Int
+	events   *eventSystem
+	filters  *filterManager
+	chain    *canonicalChain
 }
 
-func NewAPI(orm *mysql.Orm, redisCli *redis.Client) (*PublicAPI, error) {
-	return &PublicAPI{
+func NewAPI(orm store.Backend, redisCli redis.Cmdable, config Config) (*PublicAPI, error) {
+	api := &PublicAPI{
 		orm:      orm,
 		redisCli: redisCli,
-	}, nil
+		config:   config,
+		chainID:  big.NewInt(config.ChainID),
+		filters:  newFilterManager(redisCli),
+		chain:    newCanonicalChain(orm),
+	}
+	api.events = newEventSystem(api)
+	go api.chain.loop(api.latestBlock)
+	return api, nil
+}
+
+// ChainId handles eth_chainId
+func (api *PublicAPI) ChainId() (*hexutil.Big, error) {
+	return (*hexutil.Big)(api.chainID), nil
+}
+
+// BlockNumber handles eth_blockNumber, it returns the latest height synced by the infura task
+func (api *PublicAPI) BlockNumber(ctx context.Context) (_ hexutil.Uint64, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_blockNumber")
+	defer func() { tracing.EndCall(span, err) }()
+	latest, err := api.latestBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(latest), nil
 }
 
 // GetTransactionReceipt handles eth_getTransactionReceipt
-func (api *PublicAPI) GetTransactionReceipt(txHash common.Hash) (*evmtypes.TransactionReceipt, error) {
-	receipts, err := api.orm.GetTransactionReceipt(txHash.String())
+func (api *PublicAPI) GetTransactionReceipt(ctx context.Context, txHash common.Hash) (_ *evmtypes.TransactionReceipt, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getTransactionReceipt")
+	defer func() { tracing.EndCall(span, err) }()
+	receipts, err := api.orm.GetTransactionReceipt(ctx, txHash.String())
 	if err != nil {
-		log.Info("ERROR", err)
+		logger.FromContext(ctx).Error("failed to get transaction receipt", "hash", txHash.String(), "err", err)
 		return nil, err
 	}
 	if len(receipts) == 0 {
@@ -47,88 +76,207 @@ func (api *PublicAPI) GetTransactionReceipt(txHash common.Hash) (*evmtypes.Trans
 	return result, nil
 }
 
+// GetBlockReceipts handles eth_getBlockReceipts, it returns the receipts of all transactions in the block
+func (api *PublicAPI) GetBlockReceipts(ctx context.Context, blockNrOrHash BlockNumberOrHash) (_ []*evmtypes.TransactionReceipt, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getBlockReceipts")
+	defer func() { tracing.EndCall(span, err) }()
+	var blockHash string
+	if blockNrOrHash.BlockHash != nil {
+		blockHash = blockNrOrHash.BlockHash.String()
+	} else {
+		height, err := api.resolveBlockNumberOrHash(ctx, blockNrOrHash)
+		if err != nil {
+			return nil, err
+		}
+		if blockHash, err = api.blockHashByNumber(ctx, height); err != nil {
+			return nil, nil
+		}
+	}
+	receipts, err := api.orm.GetBlockReceipts(ctx, blockHash)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to get block receipts", "hash", blockHash, "err", err)
+		return nil, err
+	}
+	// 没有交易的区块返回[]，区块不存在时返回null
+	if len(receipts) == 0 && blockNrOrHash.BlockHash != nil {
+		if _, err := api.orm.GetBlockByHash(ctx, blockHash); err != nil {
+			return nil, nil
+		}
+	}
+	result := make([]*evmtypes.TransactionReceipt, len(receipts))
+	for i, receipt := range receipts {
+		result[i] = convertTransactionReceipt(receipt)
+	}
+	return result, nil
+}
+
 // GetLogs returns logs matching the given argument that are stored within the state.
 // https://github.com/ethereum/wiki/wiki/JSON-RPC#eth_getLogs
 // GetLogs handles eth_getLogs
-func (api *PublicAPI) GetLogs(ctx context.Context, criteria filters.FilterCriteria) ([]*ethtypes.Log, error) {
+func (api *PublicAPI) GetLogs(ctx context.Context, criteria FilterCriteria) (_ []*ethtypes.Log, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getLogs")
+	defer func() { tracing.EndCall(span, err) }()
+	return api.getLogs(ctx, criteria)
+}
+
+// getLogs returns the logs of the criteria, it is shared by eth_getLogs and the filters
+func (api *PublicAPI) getLogs(ctx context.Context, criteria FilterCriteria) ([]*ethtypes.Log, error) {
 	var transactionLogs []types.TransactionLog
 	var err error
-	// contract address
-	addresses := make([]string, len(criteria.Addresses))
-	for i, addr := range criteria.Addresses {
-		addresses[i] = addr.String()
+	addresses, topics := logsCriteria(criteria.Addresses, criteria.Topics)
+	// 多查一条，用来判断结果是否超过了maxLogs
+	limit := 0
+	if api.config.MaxLogs > 0 {
+		limit = api.config.MaxLogs + 1
 	}
 	// 从mysql查询数据，分两种情况，一种是使用blockHash，另外一种是使用blockNum
 	if criteria.BlockHash != nil {
-		transactionLogs, err = api.orm.GetLogsByBlockHash(criteria.BlockHash.String(), addresses)
+		transactionLogs, err = api.orm.GetLogsByBlockHash(ctx, criteria.BlockHash.String(), addresses, topics, limit)
 		if err != nil {
-			log.Info("ERROR", err)
+			logger.FromContext(ctx).Error("failed to get logs", "hash", criteria.BlockHash.String(), "err", err)
 			return nil, err
 		}
+		if limit > 0 && len(transactionLogs) >= limit {
+			return nil, &LogsLimitError{MaxLogs: api.config.MaxLogs, FromBlock: -1, ToBlock: -1}
+		}
 	} else {
-		var fromBlock, toBlock int64
+		// 和以太坊一致，未指定时fromBlock和toBlock都是latest
+		fromBlockNum, toBlockNum := LatestBlockNumber, LatestBlockNumber
 		if criteria.FromBlock != nil {
-			fromBlock = criteria.FromBlock.Int64()
-		} else {
-			fromBlock = api.latestBlock()
+			fromBlockNum = BlockNumber(criteria.FromBlock.Int64())
 		}
 		if criteria.ToBlock != nil {
-			toBlock = criteria.ToBlock.Int64()
-		} else {
-			toBlock = fromBlock
+			toBlockNum = BlockNumber(criteria.ToBlock.Int64())
+		}
+		fromBlock, err := api.resolveBlockNumber(ctx, fromBlockNum)
+		if err != nil {
+			return nil, err
+		}
+		var toBlock int64
+		toBlock, err = api.resolveBlockNumber(ctx, toBlockNum)
+		if err != nil {
+			return nil, err
+		}
+		if fromBlock > toBlock {
+			return nil, &InvalidBlockRangeError{FromBlock: fromBlock, ToBlock: toBlock}
+		}
+		if maxRange := api.config.MaxBlockRange; maxRange > 0 && toBlock-fromBlock+1 > maxRange {
+			return nil, &BlockRangeError{MaxBlockRange: maxRange, FromBlock: fromBlock, ToBlock: fromBlock + maxRange - 1}
 		}
 
-		transactionLogs, err = api.orm.GetLogs(fromBlock, toBlock, addresses)
+		transactionLogs, err = api.logsInRange(ctx, fromBlock, toBlock, addresses, topics, limit)
 		if err != nil {
-			log.Info("ERROR", err)
+			logger.FromContext(ctx).Error("failed to get logs", "from", fromBlock, "to", toBlock, "err", err)
 			return nil, err
 		}
+		if limit > 0 && len(transactionLogs) >= limit {
+			// 建议的区间截止到第maxLogs+1条日志所在区块的前一个区块，
+			// fromBlock一个区块就超过maxLogs时没有可建议的区间
+			suggestedTo := transactionLogs[limit-1].BlockNumber - 1
+			if suggestedTo < fromBlock {
+				return nil, &LogsLimitError{MaxLogs: api.config.MaxLogs, FromBlock: -1, ToBlock: -1}
+			}
+			return nil, &LogsLimitError{MaxLogs: api.config.MaxLogs, FromBlock: fromBlock, ToBlock: suggestedTo}
+		}
 	}
-	ethLogs := convertLogs(transactionLogs, criteria.Topics)
+	ethLogs := convertLogs(transactionLogs, nil)
 	return ethLogs, nil
 }
 
-func (api *PublicAPI) latestBlock() int64 {
-	value, err := api.redisCli.Get(latestTaskKey)
+// logsInRange returns the logs in the block range without the orphaned ones. The heights below the
+// canonical window are final and queried by GetFinalLogs, the orphans of the window are excluded by hash.
+func (api *PublicAPI) logsInRange(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error) {
+	var logs []types.TransactionLog
+	if windowStart := api.chain.windowStart(); fromBlock < windowStart {
+		finalTo := toBlock
+		if finalTo >= windowStart {
+			finalTo = windowStart - 1
+		}
+		finalLogs, err := api.orm.GetFinalLogs(ctx, fromBlock, finalTo, addresses, topics, limit)
+		if err != nil || finalTo == toBlock || (limit > 0 && len(finalLogs) >= limit) {
+			return finalLogs, err
+		}
+		logs, fromBlock = finalLogs, windowStart
+		if limit > 0 {
+			limit -= len(logs)
+		}
+	}
+	windowLogs, err := api.orm.GetLogs(ctx, fromBlock, toBlock, addresses, topics, api.chain.orphanHashes(), limit)
 	if err != nil {
-		return 0
+		return nil, err
+	}
+	return append(logs, windowLogs...), nil
+}
+
+// logsCriteria converts the contract addresses and topics of the criteria to the values in mysql,
+// topics are filtered in sql so that the limit applies after filtering
+func logsCriteria(criteriaAddresses []common.Address, criteriaTopics [][]common.Hash) ([]string, [][]string) {
+	addresses := make([]string, len(criteriaAddresses))
+	for i, addr := range criteriaAddresses {
+		addresses[i] = addr.String()
+	}
+	topics := make([][]string, len(criteriaTopics))
+	for i, alternatives := range criteriaTopics {
+		for _, topic := range alternatives {
+			topics[i] = append(topics[i], topic.String())
+		}
 	}
-	task := infura.Task{}
-	err = json.Unmarshal([]byte(value), &task)
+	return addresses, topics
+}
+
+// latestBlock returns the latest height synced by the infura task
+func (api *PublicAPI) latestBlock(ctx context.Context) (int64, error) {
+	task, err := LatestTask(ctx, api.redisCli)
 	if err != nil {
-		return 0
+		logger.FromContext(ctx).Error("failed to get latest task", "err", err)
+		return 0, errLatestUnknown
 	}
-	return task.Height
+	return task.Height, nil
 }
 
-func (api *PublicAPI) GetBlockByNumber(blockNum rpc.BlockNumber, fullTx bool) (*evmtypes.Block, error) {
-	height := int64(blockNum)
-	if height <= 0 {
-		height = api.latestBlock()
+func (api *PublicAPI) GetBlockByNumber(ctx context.Context, blockNum BlockNumber, fullTx bool) (_ *evmtypes.Block, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getBlockByNumber")
+	defer func() { tracing.EndCall(span, err) }()
+	height, err := api.resolveBlockNumber(ctx, blockNum)
+	if err != nil {
+		return nil, err
 	}
-	block, err := api.orm.GetBlockByNumber(height)
+	block, err := api.blockByNumber(ctx, height)
 	if err != nil {
 		return nil, nil
 	}
-	evmBlock := convertBlock(block, fullTx)
-	return evmBlock, nil
+	bloom, err := api.blockBloom(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+	return convertBlock(block, fullTx, bloom), nil
 }
 
-func (api *PublicAPI) GetBlockByHash(blockHash common.Hash, fullTx bool) (*evmtypes.Block, error) {
-	block, err := api.orm.GetBlockByHash(blockHash.String())
+func (api *PublicAPI) GetBlockByHash(ctx context.Context, blockHash common.Hash, fullTx bool) (_ *evmtypes.Block, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getBlockByHash")
+	defer func() { tracing.EndCall(span, err) }()
+	block, err := api.orm.GetBlockByHash(ctx, blockHash.String())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bloom, err := api.blockBloom(ctx, block)
 	if err != nil {
 		return nil, err
 	}
-	evmBlock := convertBlock(block, fullTx)
-	return evmBlock, nil
+	return convertBlock(block, fullTx, bloom), nil
 }
 
-func (api *PublicAPI) GetBlockTransactionCountByNumber(blockNum rpc.BlockNumber) *hexutil.Uint {
-	height := int64(blockNum)
-	if height <= 0 {
-		height = api.latestBlock()
+func (api *PublicAPI) GetBlockTransactionCountByNumber(ctx context.Context, blockNum BlockNumber) *hexutil.Uint {
+	ctx, span := tracing.StartCall(ctx, "eth_getBlockTransactionCountByNumber")
+	defer span.End()
+	height, err := api.resolveBlockNumber(ctx, blockNum)
+	if err != nil {
+		return nil
 	}
-	block, err := api.orm.GetBlockByNumber(height)
+	block, err := api.blockByNumber(ctx, height)
 	if err != nil {
 		return nil
 	}
@@ -136,8 +284,10 @@ func (api *PublicAPI) GetBlockTransactionCountByNumber(blockNum rpc.BlockNumber)
 	return &n
 }
 
-func (api *PublicAPI) GetBlockTransactionCountByHash(blockHash common.Hash) *hexutil.Uint {
-	block, err := api.orm.GetBlockByHash(blockHash.String())
+func (api *PublicAPI) GetBlockTransactionCountByHash(ctx context.Context, blockHash common.Hash) *hexutil.Uint {
+	ctx, span := tracing.StartCall(ctx, "eth_getBlockTransactionCountByHash")
+	defer span.End()
+	block, err := api.orm.GetBlockByHash(ctx, blockHash.String())
 	if err != nil {
 		return nil
 	}
@@ -145,8 +295,27 @@ func (api *PublicAPI) GetBlockTransactionCountByHash(blockHash common.Hash) *hex
 	return &n
 }
 
-func (api *PublicAPI) GetTransactionByBlockHashAndIndex(blockHash common.Hash, idx hexutil.Uint) (*evmtypes.Transaction, error) {
-	block, err := api.orm.GetBlockByHash(blockHash.String())
+// GetTransactionByHash handles eth_getTransactionByHash
+func (api *PublicAPI) GetTransactionByHash(ctx context.Context, txHash common.Hash) (_ *evmtypes.Transaction, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getTransactionByHash")
+	defer func() { tracing.EndCall(span, err) }()
+	transactions, err := api.orm.GetTransactionByHash(ctx, txHash.String())
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to get transaction", "hash", txHash.String(), "err", err)
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+	t := transactions[0]
+	result := convertTransaction(t, t.BlockNumber, t.BlockHash)
+	return &result, nil
+}
+
+func (api *PublicAPI) GetTransactionByBlockHashAndIndex(ctx context.Context, blockHash common.Hash, idx hexutil.Uint) (_ *evmtypes.Transaction, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getTransactionByBlockHashAndIndex")
+	defer func() { tracing.EndCall(span, err) }()
+	block, err := api.orm.GetBlockByHash(ctx, blockHash.String())
 	if err != nil {
 		return nil, nil
 	}
@@ -160,12 +329,14 @@ func (api *PublicAPI) GetTransactionByBlockHashAndIndex(blockHash common.Hash, i
 	return transaction, nil
 }
 
-func (api *PublicAPI) GetTransactionByBlockNumberAndIndex(blockNum rpc.BlockNumber, idx hexutil.Uint) (*evmtypes.Transaction, error) {
-	height := int64(blockNum)
-	if height <= 0 {
-		height = api.latestBlock()
+func (api *PublicAPI) GetTransactionByBlockNumberAndIndex(ctx context.Context, blockNum BlockNumber, idx hexutil.Uint) (_ *evmtypes.Transaction, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getTransactionByBlockNumberAndIndex")
+	defer func() { tracing.EndCall(span, err) }()
+	height, err := api.resolveBlockNumber(ctx, blockNum)
+	if err != nil {
+		return nil, err
 	}
-	block, err := api.orm.GetBlockByNumber(height)
+	block, err := api.blockByNumber(ctx, height)
 	if err != nil {
 		return nil, nil
 	}
@@ -179,10 +350,12 @@ func (api *PublicAPI) GetTransactionByBlockNumberAndIndex(blockNum rpc.BlockNumb
 	return transaction, nil
 }
 
-func (api *PublicAPI) GetTransactionLogs(txHash common.Hash) ([]*ethtypes.Log, error) {
-	receipts, err := api.orm.GetTransactionReceipt(txHash.String())
+func (api *PublicAPI) GetTransactionLogs(ctx context.Context, txHash common.Hash) (_ []*ethtypes.Log, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getTransactionLogs")
+	defer func() { tracing.EndCall(span, err) }()
+	receipts, err := api.orm.GetTransactionReceipt(ctx, txHash.String())
 	if err != nil {
-		log.Info("ERROR", err)
+		logger.FromContext(ctx).Error("failed to get transaction receipt", "hash", txHash.String(), "err", err)
 		return nil, err
 	}
 	if len(receipts) == 0 {
@@ -196,30 +369,16 @@ func (api *PublicAPI) GetTransactionLogs(txHash common.Hash) ([]*ethtypes.Log, e
 	return result, nil
 }
 
-func (api *PublicAPI) GetCode(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
-	blockNumber, err := api.convertToBlockNumber(blockNrOrHash)
+func (api *PublicAPI) GetCode(ctx context.Context, address common.Address, blockNrOrHash BlockNumberOrHash) (_ hexutil.Bytes, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getCode")
+	defer func() { tracing.EndCall(span, err) }()
+	blockNumber, err := api.resolveBlockNumberOrHash(ctx, blockNrOrHash)
 	if err != nil {
 		return nil, err
 	}
-	contractCode, err := api.orm.GetContractCode(address.String())
-	if err != nil || (blockNumber > 0 && contractCode.BlockNumber > blockNumber) {
+	contractCode, err := api.orm.GetContractCode(ctx, address.String())
+	if err != nil || contractCode.BlockNumber > blockNumber {
 		return nil, nil // 没有查询结果时返回nil，不返回错误
 	}
 	return hexutil.MustDecode(contractCode.Code), nil
 }
-
-// 参考以太坊源码，返回error信息和以太坊保持一致
-func (api *PublicAPI) convertToBlockNumber(blockNrOrHash rpc.BlockNumberOrHash) (int64, error) {
-	if blockNr, ok := blockNrOrHash.Number(); ok {
-		return int64(blockNr), nil
-	}
-
-	if hash, ok := blockNrOrHash.Hash(); ok {
-		block, err := api.orm.GetBlockByHash(hash.String())
-		if err != nil {
-			return 0, errors.New("header for hash not found")
-		}
-		return block.Number, nil
-	}
-	return 0, errors.New("invalid arguments; neither block nor hash specified")
-}
@@ -0,0 +1,42 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/okex/infura-service/redis"
+)
+
+// newTestAPI returns the api of the backend with the latest indexed height in miniredis
+func newTestAPI(t *testing.T, backend *fakeBackend, latest int64, config Config) (*PublicAPI, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	mr.Set(latestTaskKey, fmt.Sprintf(`{"height":%d}`, latest))
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PublicAPI{
+		orm:      backend,
+		redisCli: redisCli,
+		config:   config,
+		filters:  newFilterManager(redisCli),
+		chain:    newCanonicalChain(backend),
+	}, mr
+}
+
+func TestGetLogsInvertedRange(t *testing.T) {
+	api, _ := newTestAPI(t, &fakeBackend{}, 100, Config{MaxBlockRange: 10})
+	_, err := api.GetLogs(context.Background(), FilterCriteria{filters.FilterCriteria{
+		FromBlock: big.NewInt(50),
+		ToBlock:   big.NewInt(40),
+	}})
+	var rangeErr *InvalidBlockRangeError
+	if !errors.As(err, &rangeErr) || rangeErr.ErrorCode() != InvalidParamsErrorCode {
+		t.Fatalf("inverted range: %v, want invalid params", err)
+	}
+}
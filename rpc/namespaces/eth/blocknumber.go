@@ -0,0 +1,179 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/eth/filters"
+)
+
+// BlockNumber is the block number or tag of the requests, go-ethereum v1.10 does not know safe and finalized yet.
+// Earliest is negative as in later go-ethereum versions, so that it is not taken for block 0.
+type BlockNumber int64
+
+const (
+	EarliestBlockNumber  = BlockNumber(-5)
+	SafeBlockNumber      = BlockNumber(-4)
+	FinalizedBlockNumber = BlockNumber(-3)
+	PendingBlockNumber   = BlockNumber(-2)
+	LatestBlockNumber    = BlockNumber(-1)
+)
+
+var blockTags = map[string]BlockNumber{
+	"earliest":  EarliestBlockNumber,
+	"latest":    LatestBlockNumber,
+	"pending":   PendingBlockNumber,
+	"finalized": FinalizedBlockNumber,
+	"safe":      SafeBlockNumber,
+}
+
+// UnmarshalJSON parses a block tag or a hex block number
+func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
+	input := strings.TrimSpace(string(data))
+	if len(input) >= 2 && input[0] == '"' && input[len(input)-1] == '"' {
+		input = input[1 : len(input)-1]
+	}
+	if tag, ok := blockTags[input]; ok {
+		*bn = tag
+		return nil
+	}
+	number, err := hexutil.DecodeUint64(input)
+	if err != nil {
+		return err
+	}
+	if number > math.MaxInt64 {
+		return fmt.Errorf("block number larger than int64")
+	}
+	*bn = BlockNumber(number)
+	return nil
+}
+
+// BlockNumberOrHash is a block number, tag or hash, in the format of EIP-1898
+type BlockNumberOrHash struct {
+	BlockNumber *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash   *common.Hash `json:"blockHash,omitempty"`
+}
+
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	type erased BlockNumberOrHash
+	e := erased{}
+	if err := json.Unmarshal(data, &e); err == nil {
+		if e.BlockNumber != nil && e.BlockHash != nil {
+			return fmt.Errorf("cannot specify both BlockHash and BlockNumber, choose one or the other")
+		}
+		*bnh = BlockNumberOrHash(e)
+		return nil
+	}
+	var input string
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	if len(input) == 66 {
+		hash := common.Hash{}
+		if err := hash.UnmarshalText([]byte(input)); err != nil {
+			return err
+		}
+		bnh.BlockHash = &hash
+		return nil
+	}
+	var bn BlockNumber
+	if err := bn.UnmarshalJSON([]byte(input)); err != nil {
+		return err
+	}
+	bnh.BlockNumber = &bn
+	return nil
+}
+
+// FilterCriteria is the filters.FilterCriteria accepting all block tags in fromBlock and toBlock.
+// The tags are kept as negative numbers and resolved by resolveBlockNumber.
+type FilterCriteria struct {
+	filters.FilterCriteria
+}
+
+func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var fromBlock, toBlock *BlockNumber
+	for name, bn := range map[string]**BlockNumber{"fromBlock": &fromBlock, "toBlock": &toBlock} {
+		raw, ok := fields[name]
+		if !ok || string(raw) == "null" {
+			continue
+		}
+		*bn = new(BlockNumber)
+		if err := (*bn).UnmarshalJSON(raw); err != nil {
+			return err
+		}
+		delete(fields, name)
+	}
+	// 区块号以外的字段仍由go-ethereum解析
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := args.FilterCriteria.UnmarshalJSON(rest); err != nil {
+		return err
+	}
+	if args.BlockHash != nil && (fromBlock != nil || toBlock != nil) {
+		return errors.New("cannot specify both BlockHash and FromBlock/ToBlock, choose one or the other")
+	}
+	if fromBlock != nil {
+		args.FromBlock = big.NewInt(int64(*fromBlock))
+	}
+	if toBlock != nil {
+		args.ToBlock = big.NewInt(int64(*toBlock))
+	}
+	return nil
+}
+
+// resolveBlockNumber returns the height of the block number or tag in the indexed chain.
+// Pending is the same as latest as there is no mempool, safe and finalized are FinalityDepth
+// blocks behind the latest. Heights above the indexed tip are rejected with NotIndexedError.
+func (api *PublicAPI) resolveBlockNumber(ctx context.Context, blockNum BlockNumber) (int64, error) {
+	latest, err := api.latestBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	switch blockNum {
+	case EarliestBlockNumber:
+		return api.orm.GetEarliestBlockNumber(ctx)
+	case LatestBlockNumber, PendingBlockNumber:
+		return latest, nil
+	case SafeBlockNumber, FinalizedBlockNumber:
+		if height := latest - api.config.FinalityDepth; height > 0 {
+			return height, nil
+		}
+		return 0, nil
+	}
+	height := int64(blockNum)
+	if height < 0 {
+		return 0, fmt.Errorf("invalid block number %d", height)
+	}
+	if height > latest {
+		return 0, &NotIndexedError{Height: height, Latest: latest}
+	}
+	return height, nil
+}
+
+// resolveBlockNumberOrHash returns the height of the block number, tag or hash
+func (api *PublicAPI) resolveBlockNumberOrHash(ctx context.Context, blockNrOrHash BlockNumberOrHash) (int64, error) {
+	if blockNrOrHash.BlockNumber != nil {
+		return api.resolveBlockNumber(ctx, *blockNrOrHash.BlockNumber)
+	}
+	if blockNrOrHash.BlockHash != nil {
+		block, err := api.orm.GetBlockByHash(ctx, blockNrOrHash.BlockHash.String())
+		if err != nil {
+			return 0, errors.New("header for hash not found")
+		}
+		return block.Number, nil
+	}
+	return 0, errors.New("invalid arguments; neither block nor hash specified")
+}
@@ -0,0 +1,46 @@
+package eth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBlockNumberUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  BlockNumber
+	}{
+		{`"earliest"`, EarliestBlockNumber},
+		{`"latest"`, LatestBlockNumber},
+		{`"pending"`, PendingBlockNumber},
+		{`"safe"`, SafeBlockNumber},
+		{`"finalized"`, FinalizedBlockNumber},
+		{`"0x0"`, 0},
+		{`"0x10"`, 16},
+	}
+	for _, tt := range tests {
+		var bn BlockNumber
+		if err := json.Unmarshal([]byte(tt.input), &bn); err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if bn != tt.want {
+			t.Fatalf("%s is %d, want %d", tt.input, bn, tt.want)
+		}
+	}
+	if EarliestBlockNumber >= 0 {
+		t.Fatal("earliest is a block height")
+	}
+}
+
+func TestFilterCriteriaBlockTags(t *testing.T) {
+	var criteria FilterCriteria
+	if err := json.Unmarshal([]byte(`{"fromBlock":"earliest","toBlock":"0x0"}`), &criteria); err != nil {
+		t.Fatal(err)
+	}
+	if from := BlockNumber(criteria.FromBlock.Int64()); from != EarliestBlockNumber {
+		t.Fatalf("fromBlock is %d, want earliest", from)
+	}
+	if to := criteria.ToBlock.Int64(); to != 0 {
+		t.Fatalf("toBlock is %d, want block 0", to)
+	}
+}
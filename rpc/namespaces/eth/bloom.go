@@ -0,0 +1,51 @@
+package eth
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/logger"
+)
+
+func createBloom(logs []*ethtypes.Log) ethtypes.Bloom {
+	return ethtypes.BytesToBloom(ethtypes.LogsBloom(logs))
+}
+
+// blockBloom returns the bloom of all logs in the block, the persisted one is used if enabled.
+// The computed bloom is only persisted once the block is past the finality depth.
+func (api *PublicAPI) blockBloom(ctx context.Context, block types.Block) (ethtypes.Bloom, error) {
+	if api.config.PersistLogsBloom {
+		value, err := api.orm.GetBlockBloom(ctx, block.Hash)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to get block bloom", "hash", block.Hash, "err", err)
+			return ethtypes.Bloom{}, err
+		}
+		if len(value) > 0 {
+			if bloomBytes, err := hexutil.Decode(value); err == nil {
+				return ethtypes.BytesToBloom(bloomBytes), nil
+			}
+		}
+	}
+
+	transactionLogs, err := api.orm.GetLogsByBlockHash(ctx, block.Hash, nil, nil, 0)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to get block logs", "hash", block.Hash, "err", err)
+		return ethtypes.Bloom{}, err
+	}
+	bloom := createBloom(convertLogs(transactionLogs, nil))
+	if api.config.PersistLogsBloom && api.finalized(ctx, block.Number) {
+		if err := api.orm.SaveBlockBloom(ctx, block.Hash, hexutil.Encode(bloom.Bytes())); err != nil {
+			logger.FromContext(ctx).Error("failed to save block bloom", "hash", block.Hash, "err", err)
+		}
+	}
+	return bloom, nil
+}
+
+// finalized reports whether the height is at least FinalityDepth blocks behind the latest,
+// false if the latest is unknown
+func (api *PublicAPI) finalized(ctx context.Context, height int64) bool {
+	latest, err := api.latestBlock(ctx)
+	return err == nil && height <= latest-api.config.FinalityDepth
+}
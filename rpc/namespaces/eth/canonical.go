@@ -0,0 +1,245 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/store"
+)
+
+// canonicalWindow is the number of blocks below the tip checked for reorgs, the blocks
+// below it are final and the last indexed block of a height is taken as canonical.
+// Orphans are only tracked in the window, so the logs below it are queried with GetFinalLogs.
+const canonicalWindow = 256
+
+var errBlockNotFound = errors.New("block not found")
+
+// header is the part of a block needed to walk the chain
+type header struct {
+	number     int64
+	hash       string
+	parentHash string
+}
+
+// canonicalChain follows the canonical blocks of the recent heights, by walking the parent hashes
+// from the tip, which is the last indexed block at the latest height. The other blocks indexed in
+// the window are orphaned. It is synced in the background by loop, the readers never wait for the
+// database.
+type canonicalChain struct {
+	orm store.Backend
+
+	mtx     sync.RWMutex
+	tip     int64
+	synced  bool
+	headers map[int64]header  // canonical blocks by height
+	orphans map[string]header // orphaned blocks by hash
+}
+
+func newCanonicalChain(orm store.Backend) *canonicalChain {
+	return &canonicalChain{
+		orm:     orm,
+		headers: make(map[int64]header),
+		orphans: make(map[string]header),
+	}
+}
+
+// loop syncs the chain up to the latest height every poll interval, a failed sync is retried
+// on the next tick and the chain synced before is kept
+func (c *canonicalChain) loop(latestBlock func(ctx context.Context) (int64, error)) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		latest, err := latestBlock(context.Background())
+		if err != nil {
+			continue
+		}
+		if err := c.sync(latest); err != nil {
+			log.Error("failed to sync canonical chain", "latest", latest, "err", err)
+		}
+	}
+}
+
+// head returns the height the chain is synced to, ok is false if it is never synced
+func (c *canonicalChain) head() (tip int64, ok bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.tip, c.synced
+}
+
+// windowStart returns the lowest height of the window, the heights below it are final.
+// All heights are taken as final until the chain is synced.
+func (c *canonicalChain) windowStart() int64 {
+	tip, ok := c.head()
+	if !ok {
+		return math.MaxInt64
+	}
+	return tip - canonicalWindow + 1
+}
+
+// sync reads the blocks of the window and replaces the chain, it is only called by loop
+func (c *canonicalChain) sync(latest int64) error {
+	from := latest - canonicalWindow + 1
+	if from < 0 {
+		from = 0
+	}
+	blocks, err := c.orm.GetBlocksByRange(context.Background(), from, latest)
+	if err != nil {
+		return err
+	}
+	// blocks are ordered by number and id, the last one of a height is indexed last
+	byNumber := make(map[int64][]header)
+	for _, block := range blocks {
+		byNumber[block.Number] = append(byNumber[block.Number], header{
+			number:     block.Number,
+			hash:       block.Hash,
+			parentHash: block.ParentHash,
+		})
+	}
+
+	headers := make(map[int64]header)
+	orphans := make(map[string]header)
+	var parentHash string
+	for number := latest; number >= from; number-- {
+		candidates := byNumber[number]
+		if len(candidates) == 0 {
+			parentHash = ""
+			continue
+		}
+		canonical := candidates[len(candidates)-1]
+		for _, h := range candidates {
+			if h.hash == parentHash {
+				canonical = h
+			}
+		}
+		headers[number] = canonical
+		for _, h := range candidates {
+			if h.hash != canonical.hash {
+				orphans[h.hash] = h
+			}
+		}
+		parentHash = canonical.parentHash
+	}
+
+	// 只在替换时持有写锁，读数据库时不阻塞读者
+	c.mtx.Lock()
+	// the replaced canonical blocks and the known orphans are kept while in the window,
+	// even if the indexer deleted them
+	for hash, h := range c.orphans {
+		if _, ok := orphans[hash]; !ok && h.number >= from && headers[h.number].hash != hash {
+			orphans[hash] = h
+		}
+	}
+	reorged := false
+	for number, h := range c.headers {
+		if canonical, ok := headers[number]; ok && canonical.hash != h.hash {
+			log.Warn("chain reorg", "number", number, "old", h.hash, "new", canonical.hash)
+			orphans[h.hash] = h
+			reorged = true
+		}
+	}
+	c.headers = headers
+	c.orphans = orphans
+	c.tip = latest
+	c.synced = true
+	c.mtx.Unlock()
+
+	if reorged {
+		store.Purge(c.orm)
+	}
+	return nil
+}
+
+// hash returns the canonical hash at the height, empty if the height is not within the window
+func (c *canonicalChain) hash(number int64) string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.headers[number].hash
+}
+
+// orphanHashes returns the hashes of the orphaned blocks in the window
+func (c *canonicalChain) orphanHashes() []string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	hashes := make([]string, 0, len(c.orphans))
+	for hash := range c.orphans {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// reorged walks the orphaned blocks from hash down to the canonical chain. It returns the
+// orphaned blocks from high to low and the height of the common ancestor, ok is false if
+// hash is not orphaned.
+func (c *canonicalChain) reorged(hash string) (orphans []header, ancestor int64, ok bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for {
+		h, found := c.orphans[hash]
+		if !found {
+			break
+		}
+		orphans = append(orphans, h)
+		hash = h.parentHash
+	}
+	if len(orphans) == 0 {
+		return nil, 0, false
+	}
+	return orphans, orphans[len(orphans)-1].number - 1, true
+}
+
+// canonicalLatest returns the latest height capped to the synced canonical chain, so that the
+// filters only advance over the blocks whose canonical hashes are known
+func (api *PublicAPI) canonicalLatest(ctx context.Context) (int64, error) {
+	latest, err := api.latestBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if tip, ok := api.chain.head(); ok && tip < latest {
+		return tip, nil
+	}
+	return latest, nil
+}
+
+// removedLogs returns the logs of the orphaned block matching the addresses and topics, flagged as removed
+func (api *PublicAPI) removedLogs(ctx context.Context, blockHash string, addresses []string, topics [][]string) ([]*ethtypes.Log, error) {
+	transactionLogs, err := api.orm.GetLogsByBlockHash(ctx, blockHash, addresses, topics, 0)
+	if err != nil {
+		return nil, err
+	}
+	logs := convertLogs(transactionLogs, nil)
+	for _, l := range logs {
+		l.Removed = true
+	}
+	return logs, nil
+}
+
+// blockByNumber returns the canonical block at the height
+func (api *PublicAPI) blockByNumber(ctx context.Context, height int64) (types.Block, error) {
+	if hash := api.chain.hash(height); hash != "" {
+		return api.orm.GetBlockByHash(ctx, hash)
+	}
+	return api.orm.GetBlockByNumber(ctx, height)
+}
+
+// blockHashByNumber returns the hash of the canonical block at the height
+func (api *PublicAPI) blockHashByNumber(ctx context.Context, height int64) (string, error) {
+	if hash := api.chain.hash(height); hash != "" {
+		return hash, nil
+	}
+	blocks, err := api.orm.GetBlocksByRange(ctx, height, height)
+	if err != nil {
+		return "", err
+	}
+	if len(blocks) == 0 {
+		return "", errBlockNotFound
+	}
+	return blocks[len(blocks)-1].Hash, nil
+}
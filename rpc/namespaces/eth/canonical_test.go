@@ -0,0 +1,150 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/store"
+)
+
+// fakeBackend serves the indexed blocks in memory, and one log per height of the queried ranges
+type fakeBackend struct {
+	store.Backend
+	blocks []types.Block // in index order
+	err    error
+	calls  []string
+}
+
+func (b *fakeBackend) index(number int64, fork string) {
+	b.blocks = append(b.blocks, types.Block{
+		Number:     number,
+		Hash:       fmt.Sprintf("%s%d", fork, number),
+		ParentHash: fmt.Sprintf("%s%d", fork, number-1),
+	})
+}
+
+func (b *fakeBackend) GetBlocksByRange(ctx context.Context, fromBlock, toBlock int64) ([]types.Block, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var blocks []types.Block
+	for number := fromBlock; number <= toBlock; number++ {
+		for _, block := range b.blocks {
+			if block.Number == number {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func (b *fakeBackend) logs(fromBlock, toBlock int64, limit int) []types.TransactionLog {
+	var logs []types.TransactionLog
+	for number := fromBlock; number <= toBlock && (limit <= 0 || len(logs) < limit); number++ {
+		logs = append(logs, types.TransactionLog{BlockNumber: number, Data: "0x"})
+	}
+	return logs
+}
+
+func (b *fakeBackend) GetFinalLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error) {
+	b.calls = append(b.calls, fmt.Sprintf("final %d-%d limit %d", fromBlock, toBlock, limit))
+	return b.logs(fromBlock, toBlock, limit), nil
+}
+
+func (b *fakeBackend) GetLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, orphans []string, limit int) ([]types.TransactionLog, error) {
+	b.calls = append(b.calls, fmt.Sprintf("window %d-%d orphans %v limit %d", fromBlock, toBlock, orphans, limit))
+	return b.logs(fromBlock, toBlock, limit), nil
+}
+
+func TestCanonicalChainSync(t *testing.T) {
+	backend := &fakeBackend{}
+	chain := newCanonicalChain(backend)
+	if _, ok := chain.head(); ok {
+		t.Fatal("synced before the first sync")
+	}
+	for number := int64(1); number <= 3; number++ {
+		backend.index(number, "a")
+	}
+	if err := chain.sync(3); err != nil {
+		t.Fatal(err)
+	}
+	if tip, ok := chain.head(); !ok || tip != 3 || chain.hash(3) != "a3" {
+		t.Fatalf("head %d %v, hash %s", tip, ok, chain.hash(3))
+	}
+
+	// b3 replaces a3 and the chain continues on b
+	backend.index(3, "b")
+	backend.blocks[len(backend.blocks)-1].ParentHash = "a2"
+	backend.index(4, "b")
+	if err := chain.sync(4); err != nil {
+		t.Fatal(err)
+	}
+	if chain.hash(3) != "b3" || fmt.Sprint(chain.orphanHashes()) != "[a3]" {
+		t.Fatalf("hash of 3 is %s, orphans %v", chain.hash(3), chain.orphanHashes())
+	}
+	if orphans, ancestor, ok := chain.reorged("a3"); !ok || ancestor != 2 || len(orphans) != 1 {
+		t.Fatalf("reorged %v %d %v", orphans, ancestor, ok)
+	}
+
+	// a failed sync keeps the chain
+	backend.err = errors.New("connection refused")
+	if err := chain.sync(5); err == nil {
+		t.Fatal("sync succeeded")
+	}
+	if tip, _ := chain.head(); tip != 4 || chain.hash(4) != "b4" {
+		t.Fatalf("chain is lost, tip %d", tip)
+	}
+}
+
+func TestLogsInRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		synced    bool
+		fromBlock int64
+		toBlock   int64
+		limit     int
+		want      []string
+	}{
+		{name: "not synced", fromBlock: 990, toBlock: 1000, want: []string{"final 990-1000 limit 0"}},
+		{name: "window", synced: true, fromBlock: 900, toBlock: 1000, want: []string{"window 900-1000 orphans [] limit 0"}},
+		{name: "final", synced: true, fromBlock: 100, toBlock: 200, want: []string{"final 100-200 limit 0"}},
+		{name: "both", synced: true, fromBlock: 740, toBlock: 750, limit: 20,
+			want: []string{"final 740-744 limit 20", "window 745-750 orphans [] limit 15"}},
+		{name: "limit reached below the window", synced: true, fromBlock: 700, toBlock: 750, limit: 10,
+			want: []string{"final 700-744 limit 10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{}
+			api := &PublicAPI{orm: backend, chain: newCanonicalChain(backend)}
+			if tt.synced {
+				// the window is [745, 1000]
+				if err := api.chain.sync(1000); err != nil {
+					t.Fatal(err)
+				}
+			}
+			logs, err := api.logsInRange(context.Background(), tt.fromBlock, tt.toBlock, nil, nil, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(backend.calls) != fmt.Sprint(tt.want) {
+				t.Fatalf("queries %v, want %v", backend.calls, tt.want)
+			}
+			want := int(tt.toBlock - tt.fromBlock + 1)
+			if tt.limit > 0 && tt.limit < want {
+				want = tt.limit
+			}
+			if len(logs) != want {
+				t.Fatalf("got %d logs, want %d", len(logs), want)
+			}
+			for i, l := range logs {
+				if l.BlockNumber != tt.fromBlock+int64(i) {
+					t.Fatalf("log %d is of block %d", i, l.BlockNumber)
+				}
+			}
+		})
+	}
+}
@@ -10,14 +10,14 @@ import (
 	"github.com/okex/exchain/x/infura/types"
 )
 
-func convertBlock(block types.Block, fullTx bool) *evmtypes.Block {
+func convertBlock(block types.Block, fullTx bool, bloom ethtypes.Bloom) *evmtypes.Block {
 	evmBlock := &evmtypes.Block{
 		Number:           hexutil.Uint64(block.Number),
 		Hash:             common.HexToHash(block.Hash),
 		ParentHash:       common.HexToHash(block.ParentHash),
 		Nonce:            evmtypes.BlockNonce{},
 		UncleHash:        ethtypes.EmptyUncleHash,
-		LogsBloom:        defaultLogsBloom,
+		LogsBloom:        bloom,
 		TransactionsRoot: common.HexToHash(block.TransactionsRoot),
 		StateRoot:        common.HexToHash(block.StateRoot),
 		Miner:            common.HexToAddress(block.Miner),
@@ -105,8 +105,8 @@ func convertTransactionReceipt(receipt types.TransactionReceipt) *evmtypes.Trans
 		to = common.HexToAddress(receipt.To)
 		result.To = &to
 	}
-	result.LogsBloom = defaultLogsBloom
 	result.Logs = convertLogs(receipt.Logs, nil)
+	result.LogsBloom = createBloom(result.Logs)
 	return result
 }
 
@@ -0,0 +1,100 @@
+package eth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	// NotIndexedErrorCode is the json-rpc error code of requests past the indexed height
+	NotIndexedErrorCode = -32001
+	// InvalidParamsErrorCode is the json-rpc error code of invalid method parameters
+	InvalidParamsErrorCode = -32602
+	// LimitExceededErrorCode is the json-rpc error code of queries over the configured limits
+	LimitExceededErrorCode = -32005
+)
+
+// errLatestUnknown is returned when the latest height of the infura task can not be read from redis
+var errLatestUnknown = errors.New("latest indexed block is unknown")
+
+// NotIndexedError is returned when the requested block is not synced by the infura task yet
+type NotIndexedError struct {
+	Height int64
+	Latest int64
+}
+
+func (e *NotIndexedError) Error() string {
+	return fmt.Sprintf("block %d is not indexed yet, latest indexed block is %d", e.Height, e.Latest)
+}
+
+func (e *NotIndexedError) ErrorCode() int {
+	return NotIndexedErrorCode
+}
+
+// blockRange is the error data of the suggested block range, same as other providers
+type blockRange struct {
+	From hexutil.Uint64 `json:"from"`
+	To   hexutil.Uint64 `json:"to"`
+}
+
+// LogsLimitError is returned when eth_getLogs matches more logs than the configured max
+type LogsLimitError struct {
+	MaxLogs   int
+	FromBlock int64
+	ToBlock   int64 // negative if there is no range to suggest, e.g. queried by block hash or a single block exceeds the max
+}
+
+func (e *LogsLimitError) Error() string {
+	if e.ToBlock < 0 {
+		return fmt.Sprintf("query returned more than %d results", e.MaxLogs)
+	}
+	return fmt.Sprintf("query returned more than %d results, try with this block range [%#x, %#x]",
+		e.MaxLogs, e.FromBlock, e.ToBlock)
+}
+
+func (e *LogsLimitError) ErrorCode() int {
+	return LimitExceededErrorCode
+}
+
+func (e *LogsLimitError) ErrorData() interface{} {
+	if e.ToBlock < 0 {
+		return nil
+	}
+	return blockRange{From: hexutil.Uint64(e.FromBlock), To: hexutil.Uint64(e.ToBlock)}
+}
+
+// BlockRangeError is returned when the block range of eth_getLogs is wider than the configured max
+type BlockRangeError struct {
+	MaxBlockRange int64
+	FromBlock     int64
+	ToBlock       int64
+}
+
+func (e *BlockRangeError) Error() string {
+	return fmt.Sprintf("block range exceeds %d blocks, try with this block range [%#x, %#x]",
+		e.MaxBlockRange, e.FromBlock, e.ToBlock)
+}
+
+func (e *BlockRangeError) ErrorCode() int {
+	return LimitExceededErrorCode
+}
+
+func (e *BlockRangeError) ErrorData() interface{} {
+	return blockRange{From: hexutil.Uint64(e.FromBlock), To: hexutil.Uint64(e.ToBlock)}
+}
+
+// InvalidBlockRangeError is returned when fromBlock of eth_getLogs is after toBlock
+type InvalidBlockRangeError struct {
+	FromBlock int64
+	ToBlock   int64
+}
+
+func (e *InvalidBlockRangeError) Error() string {
+	return fmt.Sprintf("invalid block range params, fromBlock %#x is after toBlock %#x", e.FromBlock, e.ToBlock)
+}
+
+func (e *InvalidBlockRangeError) ErrorCode() int {
+	return InvalidParamsErrorCode
+}
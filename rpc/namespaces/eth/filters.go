@@ -0,0 +1,342 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/tracing"
+)
+
+const (
+	filterKeyPrefix = "infura_filter_"
+	// filters are removed if not polled within this duration, same as go-ethereum
+	filterTimeout = 5 * time.Minute
+	// concurrent polls of a filter are retried so that every change is returned once
+	maxFilterPollRetries = 3
+)
+
+type filterType int
+
+const (
+	logsFilter filterType = iota
+	blocksFilter
+)
+
+var (
+	errFilterNotFound = errors.New("filter not found")
+	errFilterConflict = errors.New("filter is polled concurrently, try again")
+)
+
+// filter is the polling filter state kept in redis, so that every replica can serve it
+type filter struct {
+	Type      filterType       `json:"type"`
+	FromBlock int64            `json:"fromBlock"`
+	ToBlock   int64            `json:"toBlock"` // negative means no upper bound
+	Addresses []common.Address `json:"addresses"`
+	Topics    [][]common.Hash  `json:"topics"`
+	LastBlock int64            `json:"lastBlock"` // last block delivered by eth_getFilterChanges
+	LastHash  string           `json:"lastHash"`  // hash of the last block, to detect reorgs
+}
+
+type filterManager struct {
+	redisCli redis.Cmdable
+}
+
+func newFilterManager(redisCli redis.Cmdable) *filterManager {
+	return &filterManager{
+		redisCli: redisCli,
+	}
+}
+
+func (m *filterManager) install(ctx context.Context, f *filter) (rpc.ID, error) {
+	id := rpc.NewID()
+	if err := m.save(ctx, id, f); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// save stores the filter and refreshes its expiry
+func (m *filterManager) save(ctx context.Context, id rpc.ID, f *filter) error {
+	value, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return m.redisCli.Set(ctx, filterKeyPrefix+string(id), string(value), filterTimeout)
+}
+
+// get returns the filter and its value in redis, which is compared when the filter is updated
+func (m *filterManager) get(ctx context.Context, id rpc.ID) (*filter, string, error) {
+	value, err := m.redisCli.Get(ctx, filterKeyPrefix+string(id))
+	if err == redis.Nil {
+		return nil, "", errFilterNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to get filter", "id", id, "err", err)
+		return nil, "", err
+	}
+	f := &filter{}
+	if err := json.Unmarshal([]byte(value), f); err != nil {
+		return nil, "", err
+	}
+	return f, value, nil
+}
+
+// update saves the filter read as prev and refreshes its expiry, it returns false if
+// the filter was polled or uninstalled in between
+func (m *filterManager) update(ctx context.Context, id rpc.ID, prev string, f *filter) (bool, error) {
+	value, err := json.Marshal(f)
+	if err != nil {
+		return false, err
+	}
+	return m.redisCli.CompareAndSwap(ctx, filterKeyPrefix+string(id), prev, string(value), filterTimeout)
+}
+
+func (m *filterManager) uninstall(ctx context.Context, id rpc.ID) bool {
+	found, err := m.redisCli.Del(ctx, filterKeyPrefix+string(id))
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to uninstall filter", "id", id, "err", err)
+		return false
+	}
+	return found
+}
+
+// NewFilter handles eth_newFilter
+func (api *PublicAPI) NewFilter(ctx context.Context, criteria FilterCriteria) (_ rpc.ID, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_newFilter")
+	defer func() { tracing.EndCall(span, err) }()
+	latest, err := api.canonicalLatest(ctx)
+	if err != nil {
+		return "", err
+	}
+	f := &filter{
+		Type:      logsFilter,
+		FromBlock: latest,
+		ToBlock:   -1,
+		Addresses: criteria.Addresses,
+		Topics:    criteria.Topics,
+		LastBlock: latest,
+		LastHash:  api.chain.hash(latest),
+	}
+	if criteria.FromBlock != nil {
+		if f.FromBlock, err = api.resolveFilterBlock(ctx, BlockNumber(criteria.FromBlock.Int64())); err != nil {
+			return "", err
+		}
+		if f.FromBlock < 0 {
+			f.FromBlock = latest
+		}
+	}
+	// latest和pending的toBlock不设上限，跟随新的区块
+	if criteria.ToBlock != nil {
+		if f.ToBlock, err = api.resolveFilterBlock(ctx, BlockNumber(criteria.ToBlock.Int64())); err != nil {
+			return "", err
+		}
+	}
+	return api.filters.install(ctx, f)
+}
+
+// resolveFilterBlock resolves the block of a filter, latest and pending are kept as -1 to
+// follow the new blocks, and heights above the indexed tip are allowed
+func (api *PublicAPI) resolveFilterBlock(ctx context.Context, blockNum BlockNumber) (int64, error) {
+	switch {
+	case blockNum == LatestBlockNumber || blockNum == PendingBlockNumber:
+		return -1, nil
+	case blockNum >= 0:
+		return int64(blockNum), nil
+	}
+	return api.resolveBlockNumber(ctx, blockNum)
+}
+
+// NewBlockFilter handles eth_newBlockFilter
+func (api *PublicAPI) NewBlockFilter(ctx context.Context) (_ rpc.ID, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_newBlockFilter")
+	defer func() { tracing.EndCall(span, err) }()
+	latest, err := api.canonicalLatest(ctx)
+	if err != nil {
+		return "", err
+	}
+	return api.filters.install(ctx, &filter{
+		Type:      blocksFilter,
+		LastBlock: latest,
+		LastHash:  api.chain.hash(latest),
+	})
+}
+
+// UninstallFilter handles eth_uninstallFilter
+func (api *PublicAPI) UninstallFilter(ctx context.Context, id rpc.ID) bool {
+	ctx, span := tracing.StartCall(ctx, "eth_uninstallFilter")
+	defer span.End()
+	return api.filters.uninstall(ctx, id)
+}
+
+// GetFilterChanges handles eth_getFilterChanges, it returns the block hashes or logs
+// since the last poll of the filter. After a reorg the logs of the orphaned blocks are
+// returned with removed set. The filter is updated with compare and swap, so that the
+// concurrent polls on any replica never return the same changes twice or skip any.
+func (api *PublicAPI) GetFilterChanges(ctx context.Context, id rpc.ID) (_ interface{}, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getFilterChanges")
+	defer func() { tracing.EndCall(span, err) }()
+	for i := 0; i < maxFilterPollRetries; i++ {
+		f, prev, err := api.filters.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := api.filterChanges(ctx, id, f)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := api.filters.update(ctx, id, prev, f)
+		if err != nil {
+			return nil, err
+		}
+		if updated {
+			return changes, nil
+		}
+	}
+	return nil, errFilterConflict
+}
+
+// filterChanges returns the changes since the last poll and advances the filter
+func (api *PublicAPI) filterChanges(ctx context.Context, id rpc.ID, f *filter) (interface{}, error) {
+	latest, err := api.canonicalLatest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 上次返回的区块被分叉替换时，从共同祖先重新开始
+	var orphans []header
+	if f.LastHash != "" && api.chain.hash(f.LastBlock) != f.LastHash {
+		var ancestor int64
+		var ok bool
+		if orphans, ancestor, ok = api.chain.reorged(f.LastHash); ok {
+			f.LastBlock = ancestor
+		}
+	}
+	switch f.Type {
+	case blocksFilter:
+		hashes := make([]common.Hash, 0)
+		if latest > f.LastBlock {
+			blocks, err := api.orm.GetBlocksByRange(ctx, f.LastBlock+1, latest)
+			if err != nil {
+				logger.FromContext(ctx).Error("failed to get filter changes", "id", id, "err", err)
+				return nil, err
+			}
+			for _, block := range blocks {
+				if hash := api.chain.hash(block.Number); hash != "" && hash != block.Hash {
+					continue
+				}
+				hashes = append(hashes, common.HexToHash(block.Hash))
+			}
+		}
+		f.LastBlock, f.LastHash = latest, api.chain.hash(latest)
+		return hashes, nil
+	case logsFilter:
+		logs := make([]*ethtypes.Log, 0)
+		addresses, topics := logsCriteria(f.Addresses, f.Topics)
+		for _, h := range orphans {
+			if h.number < f.FromBlock || (f.ToBlock >= 0 && h.number > f.ToBlock) {
+				continue
+			}
+			removed, err := api.removedLogs(ctx, h.hash, addresses, topics)
+			if err != nil {
+				return nil, err
+			}
+			logs = append(logs, removed...)
+		}
+		fromBlock := f.FromBlock
+		if f.LastBlock+1 > fromBlock {
+			fromBlock = f.LastBlock + 1
+		}
+		toBlock := latest
+		if f.ToBlock >= 0 && f.ToBlock < toBlock {
+			toBlock = f.ToBlock
+		}
+		if fromBlock <= toBlock {
+			added, lastBlock, err := api.pollLogs(ctx, fromBlock, toBlock, addresses, topics)
+			if err != nil {
+				logger.FromContext(ctx).Error("failed to get filter changes", "id", id, "err", err)
+				return nil, err
+			}
+			logs = append(logs, added...)
+			f.LastBlock, f.LastHash = lastBlock, api.chain.hash(lastBlock)
+		}
+		return logs, nil
+	}
+	return nil, errFilterNotFound
+}
+
+// pollLogs returns the logs of a filter poll and the last block they cover. The poll is paged by the
+// max block range and the max logs, so that a filter far behind catches up over several polls
+// instead of failing every poll. A single block over the max logs is returned whole.
+func (api *PublicAPI) pollLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string) ([]*ethtypes.Log, int64, error) {
+	if maxRange := api.config.MaxBlockRange; maxRange > 0 && toBlock-fromBlock+1 > maxRange {
+		toBlock = fromBlock + maxRange - 1
+	}
+	limit := 0
+	if api.config.MaxLogs > 0 {
+		limit = api.config.MaxLogs + 1
+	}
+	transactionLogs, err := api.logsInRange(ctx, fromBlock, toBlock, addresses, topics, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if limit > 0 && len(transactionLogs) >= limit {
+		// 截止到第maxLogs+1条日志所在区块的前一个区块，丢弃不完整区块的日志
+		toBlock = transactionLogs[limit-1].BlockNumber - 1
+		if toBlock < fromBlock {
+			toBlock = fromBlock
+			if transactionLogs, err = api.logsInRange(ctx, fromBlock, toBlock, addresses, topics, 0); err != nil {
+				return nil, 0, err
+			}
+		} else {
+			n := limit - 1
+			for n > 0 && transactionLogs[n-1].BlockNumber > toBlock {
+				n--
+			}
+			transactionLogs = transactionLogs[:n]
+		}
+	}
+	return convertLogs(transactionLogs, nil), toBlock, nil
+}
+
+// GetFilterLogs handles eth_getFilterLogs, it returns all logs matching the filter criteria
+func (api *PublicAPI) GetFilterLogs(ctx context.Context, id rpc.ID) (_ []*ethtypes.Log, err error) {
+	ctx, span := tracing.StartCall(ctx, "eth_getFilterLogs")
+	defer func() { tracing.EndCall(span, err) }()
+	f, _, err := api.filters.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != logsFilter {
+		return nil, errFilterNotFound
+	}
+	toBlock, err := api.latestBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if f.ToBlock >= 0 && f.ToBlock < toBlock {
+		toBlock = f.ToBlock
+	}
+	// 过滤器的起始区块还未同步时没有日志
+	if f.FromBlock > toBlock {
+		return []*ethtypes.Log{}, nil
+	}
+	return api.getFilterLogs(ctx, f, f.FromBlock, toBlock)
+}
+
+func (api *PublicAPI) getFilterLogs(ctx context.Context, f *filter, fromBlock, toBlock int64) ([]*ethtypes.Log, error) {
+	return api.getLogs(ctx, FilterCriteria{filters.FilterCriteria{
+		FromBlock: big.NewInt(fromBlock),
+		ToBlock:   big.NewInt(toBlock),
+		Addresses: f.Addresses,
+		Topics:    f.Topics,
+	}})
+}
@@ -0,0 +1,48 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestFilterChangesOfLaggingFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []string // the block ranges of the logs of each poll
+	}{
+		{name: "paged by block range", config: Config{MaxBlockRange: 10},
+			want: []string{"6-15", "16-25", "26-35", "36-37", ""}},
+		{name: "paged by max logs", config: Config{MaxLogs: 12},
+			want: []string{"6-17", "18-29", "30-37", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, mr := newTestAPI(t, &fakeBackend{}, 5, tt.config)
+			ctx := context.Background()
+			id, err := api.NewFilter(ctx, FilterCriteria{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the filter is not polled while the indexer catches up
+			mr.Set(latestTaskKey, `{"height":37}`)
+			for i, want := range tt.want {
+				changes, err := api.GetFilterChanges(ctx, id)
+				if err != nil {
+					t.Fatalf("poll %d: %v", i, err)
+				}
+				logs := changes.([]*ethtypes.Log)
+				got := ""
+				if len(logs) > 0 {
+					got = fmt.Sprintf("%d-%d", logs[0].BlockNumber, logs[len(logs)-1].BlockNumber)
+				}
+				if got != want || len(logs) > 0 && uint64(len(logs)) != logs[len(logs)-1].BlockNumber-logs[0].BlockNumber+1 {
+					t.Fatalf("poll %d returned %d logs of %s, want %s", i, len(logs), got, want)
+				}
+			}
+		})
+	}
+}
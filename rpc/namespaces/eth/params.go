@@ -1,25 +1,18 @@
 package eth
 
-import (
-	"sync"
-
-	"github.com/ethereum/go-ethereum/common/hexutil"
-	ethtypes "github.com/ethereum/go-ethereum/core/types"
-)
-
-var (
-	bloomOnce        sync.Once
-	defaultLogsBloom ethtypes.Bloom
-)
-
 const (
 	latestTaskKey = "infura_latest_task"
-	bloomHex      = "0x00000000000000000000000000000000000000000400000000000000000000000000000000000000000000000000000000000000000000080000000000000000000000000000000000000000800000000000000000000000000000000000000000000000020000000000000000000800000000000000000000000000000000000000000000000000000400000000000000000000000000000000000000000000000000000000000000000000000000000000000000010000000000000040000000000000000000000002000000000000000000000000200000000000000020000000000000000000000000000000000000000000000000000000000000400000"
 )
 
-func init() {
-	bloomOnce.Do(func() {
-		bloomBytes, _ := hexutil.Decode(bloomHex)
-		defaultLogsBloom = ethtypes.BytesToBloom(bloomBytes)
-	})
+// Config is the configuration of the eth namespace
+type Config struct {
+	ChainID int64
+	// PersistLogsBloom saves the computed block bloom in blocks.logs_bloom
+	PersistLogsBloom bool
+	// MaxLogs is the max number of logs returned by eth_getLogs, 0 for no limit
+	MaxLogs int
+	// MaxBlockRange is the max block range of eth_getLogs, 0 for no limit
+	MaxBlockRange int64
+	// FinalityDepth is the number of blocks behind the latest of the safe and finalized tags
+	FinalityDepth int64
 }
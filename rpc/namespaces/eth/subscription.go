@@ -0,0 +1,219 @@
+package eth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/okex/exchain/x/infura/types"
+)
+
+const (
+	pollInterval     = time.Second
+	chainEventBuffer = 128
+)
+
+// chainEvent is a block newly synced by the infura task together with its logs, or a block
+// orphaned by a reorg whose logs are flagged as removed
+type chainEvent struct {
+	block   types.Block
+	logs    []*ethtypes.Log
+	removed bool
+}
+
+// eventSystem polls the latest height of the infura task and feeds the new blocks to subscribers
+type eventSystem struct {
+	api         *PublicAPI
+	feed        event.Feed
+	once        sync.Once
+	subscribers int32
+}
+
+func newEventSystem(api *PublicAPI) *eventSystem {
+	return &eventSystem{
+		api: api,
+	}
+}
+
+func (es *eventSystem) subscribe(ch chan<- chainEvent) event.Subscription {
+	es.once.Do(func() {
+		go es.loop()
+	})
+	atomic.AddInt32(&es.subscribers, 1)
+	return &countedSubscription{
+		Subscription: es.feed.Subscribe(ch),
+		counter:      &es.subscribers,
+	}
+}
+
+func (es *eventSystem) loop() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	// height is -1 until the latest height is read, so that no block before the subscription is replayed
+	height, hash := int64(-1), ""
+	for range ticker.C {
+		// 跟随已同步的规范链，redis或mysql不可用时保留当前高度，恢复后从该高度继续
+		latest, ok := es.api.chain.head()
+		if !ok {
+			continue
+		}
+		// 没有订阅者时不查询mysql，只跟进高度
+		if height < 0 || atomic.LoadInt32(&es.subscribers) == 0 {
+			height, hash = latest, es.api.chain.hash(latest)
+			continue
+		}
+		// 发生reorg时先发出分叉上被移除的日志，再从共同祖先继续
+		if hash != "" && es.api.chain.hash(height) != hash {
+			if orphans, ancestor, ok := es.api.chain.reorged(hash); ok {
+				for _, h := range orphans {
+					ev, err := es.loadRemoved(h)
+					if err != nil {
+						log.Error("failed to load removed logs", "hash", h.hash, "err", err)
+						continue
+					}
+					es.feed.Send(ev)
+				}
+				height, hash = ancestor, es.api.chain.hash(ancestor)
+			}
+		}
+		for height < latest {
+			ev, err := es.load(height + 1)
+			if err != nil {
+				log.Error("failed to load chain event", "height", height+1, "err", err)
+				break
+			}
+			es.feed.Send(ev)
+			height, hash = height+1, ev.block.Hash
+		}
+	}
+}
+
+func (es *eventSystem) load(height int64) (chainEvent, error) {
+	ctx := context.Background()
+	block, err := es.api.blockByNumber(ctx, height)
+	if err != nil {
+		return chainEvent{}, err
+	}
+	transactionLogs, err := es.api.orm.GetLogsByBlockHash(ctx, block.Hash, nil, nil, 0)
+	if err != nil {
+		return chainEvent{}, err
+	}
+	return chainEvent{
+		block: block,
+		logs:  convertLogs(transactionLogs, nil),
+	}, nil
+}
+
+func (es *eventSystem) loadRemoved(h header) (chainEvent, error) {
+	logs, err := es.api.removedLogs(context.Background(), h.hash, nil, nil)
+	if err != nil {
+		return chainEvent{}, err
+	}
+	return chainEvent{
+		block:   types.Block{Number: h.number, Hash: h.hash, ParentHash: h.parentHash},
+		logs:    logs,
+		removed: true,
+	}, nil
+}
+
+type countedSubscription struct {
+	event.Subscription
+	counter *int32
+	once    sync.Once
+}
+
+func (s *countedSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		atomic.AddInt32(s.counter, -1)
+	})
+	s.Subscription.Unsubscribe()
+}
+
+// NewHeads handles eth_subscribe("newHeads")
+func (api *PublicAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan chainEvent, chainEventBuffer)
+		sub := api.events.subscribe(events)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				if ev.removed {
+					continue
+				}
+				notifier.Notify(rpcSub.ID, convertBlock(ev.block, false, createBloom(ev.logs)))
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// Logs handles eth_subscribe("logs")
+func (api *PublicAPI) Logs(ctx context.Context, criteria filters.FilterCriteria) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan chainEvent, chainEventBuffer)
+		sub := api.events.subscribe(events)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				for _, l := range filterLogs(ev.logs, criteria.Addresses, criteria.Topics) {
+					notifier.Notify(rpcSub.ID, l)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// filterLogs returns the logs matching the given addresses and topics
+func filterLogs(logs []*ethtypes.Log, addresses []common.Address, topics [][]common.Hash) []*ethtypes.Log {
+	var result []*ethtypes.Log
+	for _, l := range logs {
+		if len(addresses) > 0 && !containsAddress(addresses, l.Address) {
+			continue
+		}
+		if len(topics) > 0 && !matchTopics(l.Topics, topics) {
+			continue
+		}
+		result = append(result, l)
+	}
+	return result
+}
+
+func containsAddress(addresses []common.Address, addr common.Address) bool {
+	for _, a := range addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,12 @@ package eth
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/okex/exchain/x/infura"
+	"github.com/okex/infura-service/redis"
 )
 
 // The Topic list restricts matches to particular event topics. Each event has a list
@@ -48,3 +52,14 @@ func matchTopics(topics []common.Hash, matches [][]common.Hash) bool {
 	}
 	return true
 }
+
+// LatestTask returns the latest task of the infura indexer saved in redis
+func LatestTask(ctx context.Context, redisCli redis.Cmdable) (infura.Task, error) {
+	task := infura.Task{}
+	value, err := redisCli.Get(ctx, latestTaskKey)
+	if err != nil {
+		return task, err
+	}
+	err = json.Unmarshal([]byte(value), &task)
+	return task, err
+}
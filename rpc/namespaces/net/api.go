@@ -0,0 +1,20 @@
+package net
+
+import (
+	"fmt"
+)
+
+type PublicAPI struct {
+	networkVersion string
+}
+
+func NewAPI(chainID int64) *PublicAPI {
+	return &PublicAPI{
+		networkVersion: fmt.Sprintf("%d", chainID),
+	}
+}
+
+// Version handles net_version
+func (api *PublicAPI) Version() string {
+	return api.networkVersion
+}
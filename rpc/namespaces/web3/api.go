@@ -0,0 +1,23 @@
+package web3
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const clientName = "infura-service"
+
+type PublicAPI struct {
+	clientVersion string
+}
+
+func NewAPI(version string) *PublicAPI {
+	return &PublicAPI{
+		clientVersion: fmt.Sprintf("%s/v%s/%s-%s/%s", clientName, version, runtime.GOOS, runtime.GOARCH, runtime.Version()),
+	}
+}
+
+// ClientVersion handles web3_clientVersion
+func (api *PublicAPI) ClientVersion() string {
+	return api.clientVersion
+}
@@ -0,0 +1,305 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/okex/infura-service/rpc/namespaces/eth"
+	"github.com/okex/infura-service/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	upstreamTimeout   = 30 * time.Second
+	upstreamErrorCode = -32603
+)
+
+// forwardMethods are the methods served by the upstream node. Anything else, e.g. the admin, debug,
+// personal, miner and txpool namespaces of the node, is answered by the local server with -32601.
+var forwardMethods = map[string]bool{
+	"eth_blockNumber":                         true,
+	"eth_call":                                true,
+	"eth_chainId":                             true,
+	"eth_estimateGas":                         true,
+	"eth_feeHistory":                          true,
+	"eth_gasPrice":                            true,
+	"eth_getBalance":                          true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockReceipts":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getCode":                             true,
+	"eth_getLogs":                             true,
+	"eth_getProof":                            true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionCount":                 true,
+	"eth_getTransactionReceipt":               true,
+	"eth_maxPriorityFeePerGas":                true,
+	"eth_protocolVersion":                     true,
+	"eth_sendRawTransaction":                  true,
+	"eth_syncing":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"net_version":                             true,
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+}
+
+// needFallback reports whether the local response carries no data, so the call
+// should be answered by the upstream node instead
+func (msg *jsonrpcMessage) needFallback() bool {
+	if msg.Error != nil {
+		return msg.Error.Code == eth.NotIndexedErrorCode
+	}
+	return len(msg.Result) == 0 || bytes.Equal(msg.Result, null)
+}
+
+// fallbackHandler serves json-rpc requests with the local rpc server, and forwards the allowed
+// methods which are not served locally or have no local data to the upstream full node
+type fallbackHandler struct {
+	local    http.Handler
+	upstream atomic.Value // string, empty to serve all requests locally
+	client   *http.Client
+	methods  map[string]bool
+}
+
+func newFallbackHandler(local http.Handler, upstream string, apis []rpc.API) *fallbackHandler {
+	h := &fallbackHandler{
+		local:   local,
+		client:  &http.Client{Timeout: upstreamTimeout},
+		methods: methodNames(apis),
+	}
+	h.setUpstream(upstream)
+	return h
+}
+
+func (h *fallbackHandler) setUpstream(upstream string) {
+	h.upstream.Store(upstream)
+}
+
+func (h *fallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream := h.upstream.Load().(string)
+	if r.Method != http.MethodPost || upstream == "" {
+		h.local.ServeHTTP(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestContentLength))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	msgs, batch, err := parseMessages(body)
+	if err != nil || len(msgs) == 0 {
+		// let the local server report the malformed request
+		h.local.ServeHTTP(w, r)
+		return
+	}
+
+	var local, remote []int
+	fallback := false
+	for i, msg := range msgs {
+		switch {
+		case !forwardMethods[msg.Method]:
+			local = append(local, i)
+		case h.methods[msg.Method]:
+			local = append(local, i)
+			fallback = true
+		default:
+			remote = append(remote, i)
+		}
+	}
+	if len(remote) == 0 && !fallback {
+		// 没有需要转发的调用，直接由本地服务处理原始请求
+		h.local.ServeHTTP(w, r)
+		return
+	}
+
+	results := make([]*jsonrpcMessage, len(msgs))
+	if len(local) > 0 {
+		localMsgs := make([]*jsonrpcMessage, len(local))
+		for i, idx := range local {
+			localMsgs[i] = msgs[idx]
+		}
+		localResps, rejected := h.serveLocal(r, body, len(local) == len(msgs), localMsgs)
+		if rejected != nil {
+			// the local server rejected the http request, e.g. unsupported content type
+			copyResponse(w, rejected)
+			return
+		}
+		for _, idx := range local {
+			msg := msgs[idx]
+			if msg.isNotification() {
+				continue
+			}
+			resp, ok := localResps[string(msg.ID)]
+			if forwardMethods[msg.Method] && (!ok || resp.needFallback()) {
+				remote = append(remote, idx)
+				continue
+			}
+			results[idx] = resp
+		}
+	}
+	if len(remote) > 0 {
+		remoteMsgs := make([]*jsonrpcMessage, len(remote))
+		for i, idx := range remote {
+			remoteMsgs[i] = msgs[idx]
+		}
+		remoteResps, err := h.forward(r, upstream, remoteMsgs)
+		for _, idx := range remote {
+			msg := msgs[idx]
+			if msg.isNotification() {
+				continue
+			}
+			if resp, ok := remoteResps[string(msg.ID)]; ok {
+				results[idx] = resp
+			} else if err != nil {
+				results[idx] = errorMessage(msg.ID, err)
+			}
+		}
+	}
+
+	var resps []*jsonrpcMessage
+	for i, msg := range msgs {
+		if msg.isNotification() {
+			continue
+		}
+		resp := results[i]
+		if resp == nil {
+			resp = &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: null}
+		}
+		resps = append(resps, resp)
+	}
+
+	w.Header().Set("content-type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(resps)
+	} else if len(resps) > 0 {
+		json.NewEncoder(w).Encode(resps[0])
+	}
+}
+
+// serveLocal executes the calls with the local rpc server in one request and returns its
+// responses keyed by id. The original body is sent if all calls are local. rejected is the
+// response of the local server if it refused the http request.
+func (h *fallbackHandler) serveLocal(r *http.Request, body []byte, all bool, msgs []*jsonrpcMessage) (map[string]*jsonrpcMessage, *httptest.ResponseRecorder) {
+	if !all {
+		body, _ = json.Marshal(msgs)
+	}
+	req := r.Clone(r.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	recorder := httptest.NewRecorder()
+	h.local.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		return nil, recorder
+	}
+	resps, _, err := parseMessages(recorder.Body.Bytes())
+	if err != nil {
+		return nil, nil
+	}
+	result := make(map[string]*jsonrpcMessage, len(resps))
+	for _, resp := range resps {
+		result[string(resp.ID)] = resp
+	}
+	return result, nil
+}
+
+func copyResponse(w http.ResponseWriter, recorder *httptest.ResponseRecorder) {
+	for key, values := range recorder.Header() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(recorder.Code)
+	w.Write(recorder.Body.Bytes())
+}
+
+// forward sends the calls to the upstream node and returns its responses keyed by id
+func (h *fallbackHandler) forward(r *http.Request, upstream string, msgs []*jsonrpcMessage) (result map[string]*jsonrpcMessage, err error) {
+	ctx, span := tracing.Tracer().Start(r.Context(), "upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemKey.String(jsonrpcSystem), attribute.Int("rpc.jsonrpc.calls", len(msgs))))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	body, err := json.Marshal(msgs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resps, _, err := parseMessages(respBody)
+	if err != nil {
+		return nil, err
+	}
+	result = make(map[string]*jsonrpcMessage, len(resps))
+	for _, resp := range resps {
+		result[string(resp.ID)] = resp
+	}
+	return result, nil
+}
+
+func errorMessage(id json.RawMessage, err error) *jsonrpcMessage {
+	return newErrorMessage(id, upstreamErrorCode, fmt.Sprintf("upstream unavailable: %s", err.Error()), nil)
+}
+
+var subscriptionType = reflect.TypeOf(&rpc.Subscription{})
+
+// methodNames returns the json-rpc method names served by the apis, in the same
+// format used by the go-ethereum rpc server. Subscriptions are only served over websocket.
+func methodNames(apis []rpc.API) map[string]bool {
+	methods := make(map[string]bool)
+	for _, api := range apis {
+		typ := reflect.TypeOf(api.Service)
+		for i := 0; i < typ.NumMethod(); i++ {
+			method := typ.Method(i)
+			if method.Type.NumOut() > 0 && method.Type.Out(0) == subscriptionType {
+				continue
+			}
+			name := []rune(method.Name)
+			name[0] = unicode.ToLower(name[0])
+			methods[api.Namespace+"_"+string(name)] = true
+		}
+	}
+	return methods
+}
@@ -0,0 +1,197 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testEthAPI serves eth_blockNumber and eth_getTransactionByHash, the transactions are never indexed
+type testEthAPI struct{}
+
+func (testEthAPI) BlockNumber() hexutil.Uint64 {
+	return 5
+}
+
+func (testEthAPI) GetTransactionByHash(hash string) (*string, error) {
+	return nil, nil
+}
+
+// upstreamStub is a json-rpc node answering every call with "upstream:<method>"
+type upstreamStub struct {
+	mtx     sync.Mutex
+	methods []string
+	status  int
+}
+
+func (s *upstreamStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	var msgs []*jsonrpcMessage
+	if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resps := make([]*jsonrpcMessage, 0, len(msgs))
+	s.mtx.Lock()
+	for _, msg := range msgs {
+		s.methods = append(s.methods, msg.Method)
+		result, _ := json.Marshal("upstream:" + msg.Method)
+		resps = append(resps, &jsonrpcMessage{Version: "2.0", ID: msg.ID, Result: result})
+	}
+	s.mtx.Unlock()
+	json.NewEncoder(w).Encode(resps)
+}
+
+func (s *upstreamStub) received() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.methods...)
+}
+
+func newTestFallbackHandler(t *testing.T, upstream *upstreamStub) *fallbackHandler {
+	apis := []rpc.API{{Namespace: "eth", Version: apiVersion, Service: testEthAPI{}, Public: true}}
+	local := rpc.NewServer()
+	for _, api := range apis {
+		if err := local.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node := httptest.NewServer(upstream)
+	t.Cleanup(node.Close)
+	return newFallbackHandler(local, node.URL, apis)
+}
+
+func serveJSONRPC(t *testing.T, h http.Handler, body string) []*jsonrpcMessage {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("content-type", "application/json")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	resps, _, err := parseMessages(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+	}
+	return resps
+}
+
+func resultString(t *testing.T, msg *jsonrpcMessage) string {
+	if msg.Error != nil {
+		t.Fatalf("call %s failed: %d %s", msg.ID, msg.Error.Code, msg.Error.Message)
+	}
+	var result interface{}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := result.(string)
+	return s
+}
+
+func TestFallbackForwardsUnsupportedMethod(t *testing.T) {
+	upstream := &upstreamStub{}
+	h := newTestFallbackHandler(t, upstream)
+
+	resps := serveJSONRPC(t, h, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]}`)
+	if got := resultString(t, resps[0]); got != "upstream:eth_getBalance" {
+		t.Fatalf("result %q, want the upstream result", got)
+	}
+	if got := upstream.received(); len(got) != 1 || got[0] != "eth_getBalance" {
+		t.Fatalf("upstream received %v", got)
+	}
+}
+
+func TestFallbackRejectsPrivilegedMethods(t *testing.T) {
+	upstream := &upstreamStub{}
+	h := newTestFallbackHandler(t, upstream)
+
+	for _, method := range []string{"admin_peers", "personal_unlockAccount", "debug_traceTransaction", "miner_start", "txpool_content", "foo_bar"} {
+		resps := serveJSONRPC(t, h, `{"jsonrpc":"2.0","id":1,"method":"`+method+`"}`)
+		if resps[0].Error == nil || resps[0].Error.Code != -32601 {
+			t.Fatalf("%s: got %+v, want method not found", method, resps[0])
+		}
+	}
+	if got := upstream.received(); len(got) != 0 {
+		t.Fatalf("upstream received %v", got)
+	}
+}
+
+func TestFallbackOnNullResult(t *testing.T) {
+	upstream := &upstreamStub{}
+	h := newTestFallbackHandler(t, upstream)
+
+	resps := serveJSONRPC(t, h, `{"jsonrpc":"2.0","id":1,"method":"eth_getTransactionByHash","params":["0x01"]}`)
+	if got := resultString(t, resps[0]); got != "upstream:eth_getTransactionByHash" {
+		t.Fatalf("result %q, want the upstream result", got)
+	}
+
+	// the local result is kept if there is one
+	resps = serveJSONRPC(t, h, `{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}`)
+	if got := resultString(t, resps[0]); got != "0x5" {
+		t.Fatalf("result %q, want the local result", got)
+	}
+	if got := upstream.received(); len(got) != 1 {
+		t.Fatalf("upstream received %v", got)
+	}
+}
+
+func TestFallbackMixedBatchKeepsOrder(t *testing.T) {
+	upstream := &upstreamStub{}
+	h := newTestFallbackHandler(t, upstream)
+
+	resps := serveJSONRPC(t, h, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","method":"eth_blockNumber"},
+		{"jsonrpc":"2.0","id":3,"method":"eth_getTransactionByHash","params":["0x01"]},
+		{"jsonrpc":"2.0","id":4,"method":"admin_nodeInfo"}
+	]`)
+	if len(resps) != 4 {
+		t.Fatalf("got %d responses, want 4", len(resps))
+	}
+	for i, id := range []string{"1", "2", "3", "4"} {
+		if string(resps[i].ID) != id {
+			t.Fatalf("response %d has id %s, want %s", i, resps[i].ID, id)
+		}
+	}
+	if got := resultString(t, resps[0]); got != "upstream:eth_getBalance" {
+		t.Fatalf("result of 1 is %q", got)
+	}
+	if got := resultString(t, resps[1]); got != "0x5" {
+		t.Fatalf("result of 2 is %q", got)
+	}
+	if got := resultString(t, resps[2]); got != "upstream:eth_getTransactionByHash" {
+		t.Fatalf("result of 3 is %q", got)
+	}
+	if resps[3].Error == nil || resps[3].Error.Code != -32601 {
+		t.Fatalf("result of 4 is %+v, want method not found", resps[3])
+	}
+}
+
+func TestFallbackUpstreamFailure(t *testing.T) {
+	upstream := &upstreamStub{status: http.StatusBadGateway}
+	h := newTestFallbackHandler(t, upstream)
+
+	resps := serveJSONRPC(t, h, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x0","latest"]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}
+	]`)
+	if len(resps) != 2 {
+		t.Fatalf("got %d responses, want 2", len(resps))
+	}
+	if resps[0].Error == nil || resps[0].Error.Code != upstreamErrorCode {
+		t.Fatalf("result of 1 is %+v, want upstream error", resps[0])
+	}
+	if got := resultString(t, resps[1]); got != "0x5" {
+		t.Fatalf("result of 2 is %q", got)
+	}
+}
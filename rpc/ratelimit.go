@@ -0,0 +1,282 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/rpc/namespaces/eth"
+)
+
+const (
+	invalidAPIKeyErrorCode = -32600
+
+	apiKeyHeader       = "X-Api-Key"
+	apiKeyParam        = "apikey"
+	rateLimitKeyPrefix = "infura_ratelimit_"
+	maxLocalBuckets    = 10000
+
+	methodClassDefault = "default"
+	methodClassLogs    = "logs"
+)
+
+// methodClasses groups the expensive methods, so that they are limited separately
+var methodClasses = map[string]string{
+	"eth_getLogs":          methodClassLogs,
+	"eth_getFilterLogs":    methodClassLogs,
+	"eth_getFilterChanges": methodClassLogs,
+}
+
+func methodClass(method string) string {
+	if class, ok := methodClasses[method]; ok {
+		return class
+	}
+	return methodClassDefault
+}
+
+// rateLimiter authenticates the callers by api key and limits their requests with token buckets
+// per method class. The buckets are kept in redis if shared, so that the limits hold across replicas.
+type rateLimiter struct {
+	redisCli redis.Cmdable
+	local    *localBuckets
+
+	limits      atomic.Value // map[string]float64, requests per second by method class
+	apiKeys     atomic.Value // map[[sha256.Size]byte]bool, the keys are hashed so that the lookup takes the same time for any key
+	keyRequired int32
+}
+
+func newRateLimiter(config *Config, redisCli redis.Cmdable) *rateLimiter {
+	l := &rateLimiter{
+		local: newLocalBuckets(maxLocalBuckets),
+	}
+	if config.RateLimitRedis {
+		l.redisCli = redisCli
+	}
+	l.setLimits(config)
+	return l
+}
+
+// setLimits applies the rate limits and api keys of the config
+func (l *rateLimiter) setLimits(config *Config) {
+	l.limits.Store(map[string]float64{
+		methodClassDefault: config.RateLimit,
+		methodClassLogs:    config.RateLimitLogs,
+	})
+	apiKeys := make(map[[sha256.Size]byte]bool)
+	for _, key := range strings.Split(config.APIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys[sha256.Sum256([]byte(key))] = true
+		}
+	}
+	l.apiKeys.Store(apiKeys)
+	var keyRequired int32
+	if config.APIKeyRequired {
+		keyRequired = 1
+	}
+	atomic.StoreInt32(&l.keyRequired, keyRequired)
+}
+
+type callerContextKey struct{}
+
+// callerOf returns the caller identified by the middleware, the websocket connections
+// take the tokens of their calls from the buckets of the caller of the upgrade
+func callerOf(ctx context.Context) (string, bool) {
+	caller, ok := ctx.Value(callerContextKey{}).(string)
+	return caller, ok
+}
+
+func (l *rateLimiter) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, err := l.identify(c)
+		msgs, batch, ok := requestMessages(c)
+		if err != nil {
+			abortWithError(c, http.StatusUnauthorized, msgs, batch, invalidAPIKeyErrorCode, err.Error(), nil)
+			return
+		}
+		if !ok {
+			// websocket的升级请求没有body，连接上的调用在读取时限流
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), callerContextKey{}, caller))
+			c.Next()
+			return
+		}
+		if e := l.limit(c.Request.Context(), caller, msgs); e != nil {
+			if e.wait > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(e.wait.Seconds()))))
+			}
+			abortWithError(c, e.status, msgs, batch, eth.LimitExceededErrorCode, e.message, e.data())
+			return
+		}
+		c.Next()
+	}
+}
+
+// limitError rejects the calls over the rate limits
+type limitError struct {
+	status  int
+	message string
+	wait    time.Duration // 0 if the calls never get enough tokens
+}
+
+func (e *limitError) data() interface{} {
+	if e.wait > 0 {
+		return gin.H{"retryAfter": e.wait.Seconds()}
+	}
+	return nil
+}
+
+// limit takes the tokens of the calls from the buckets of the caller per method class
+func (l *rateLimiter) limit(ctx context.Context, caller string, msgs []*jsonrpcMessage) *limitError {
+	costs := make(map[string]int)
+	for _, msg := range msgs {
+		costs[methodClass(msg.Method)]++
+	}
+	limits := l.limits.Load().(map[string]float64)
+	// 超过桶容量的批量请求永远拿不到足够的令牌，直接拒绝
+	for class, cost := range costs {
+		if rate := limits[class]; rate > 0 && cost > burstOf(rate) {
+			return &limitError{
+				status:  http.StatusBadRequest,
+				message: fmt.Sprintf("batch of %d %s calls exceeds the rate limit burst of %d", cost, class, burstOf(rate)),
+			}
+		}
+	}
+	var wait time.Duration
+	for class, cost := range costs {
+		rate := limits[class]
+		if rate <= 0 {
+			continue
+		}
+		w, err := l.take(ctx, caller+"_"+class, rate, cost)
+		if err != nil {
+			// 限流存储不可用时不拒绝请求
+			logger.FromContext(ctx).Warn("failed to take rate limit tokens", "err", err)
+			continue
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return &limitError{
+			status:  http.StatusTooManyRequests,
+			message: fmt.Sprintf("rate limit exceeded, retry after %s", wait),
+			wait:    wait,
+		}
+	}
+	return nil
+}
+
+// identify returns the caller of the request, by api key from the path or header, or by ip.
+// The ip is only taken from X-Forwarded-For if the request comes from a trusted proxy.
+func (l *rateLimiter) identify(c *gin.Context) (string, error) {
+	key := c.Param(apiKeyParam)
+	if key == "" {
+		key = c.GetHeader(apiKeyHeader)
+	}
+	if l.hasAPIKeys() && key != "" {
+		if !l.validAPIKey(key) {
+			return "", fmt.Errorf("invalid api key")
+		}
+		return "key_" + key, nil
+	}
+	if atomic.LoadInt32(&l.keyRequired) == 1 {
+		return "", fmt.Errorf("api key required")
+	}
+	return "ip_" + c.ClientIP(), nil
+}
+
+func (l *rateLimiter) hasAPIKeys() bool {
+	return len(l.apiKeys.Load().(map[[sha256.Size]byte]bool)) > 0
+}
+
+// validAPIKey looks up the hash of the key, so that the time does not tell how much of a key matches
+func (l *rateLimiter) validAPIKey(key string) bool {
+	return l.apiKeys.Load().(map[[sha256.Size]byte]bool)[sha256.Sum256([]byte(key))]
+}
+
+// burstOf returns the capacity of the token buckets of the rate, one second of requests
+func burstOf(rate float64) int {
+	return int(math.Ceil(rate))
+}
+
+// take takes cost tokens from the bucket of the key, the cost must not exceed the burst
+func (l *rateLimiter) take(ctx context.Context, key string, rate float64, cost int) (time.Duration, error) {
+	burst := burstOf(rate)
+	if l.redisCli != nil {
+		return l.redisCli.TakeTokens(ctx, rateLimitKeyPrefix+key, rate, burst, cost)
+	}
+	return l.local.take(key, rate, burst, cost), nil
+}
+
+// errorMessages returns the error responses of the calls, none for the notifications
+func errorMessages(msgs []*jsonrpcMessage, code int, message string, data interface{}) []*jsonrpcMessage {
+	var resps []*jsonrpcMessage
+	for _, msg := range msgs {
+		if !msg.isNotification() {
+			resps = append(resps, newErrorMessage(msg.ID, code, message, data))
+		}
+	}
+	return resps
+}
+
+func abortWithError(c *gin.Context, status int, msgs []*jsonrpcMessage, batch bool, code int, message string, data interface{}) {
+	resps := errorMessages(msgs, code, message, data)
+	if len(resps) == 0 {
+		resps = append(resps, newErrorMessage(null, code, message, data))
+	}
+	writeMessages(c, status, resps, batch)
+	c.Abort()
+}
+
+type tokenBucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+// localBuckets keeps the token buckets in process, used when the limits are not shared by replicas.
+// At most maxLocalBuckets are kept, the least recently used bucket is evicted, as a new bucket is
+// the same as an evicted one which has been refilled.
+type localBuckets struct {
+	mtx     sync.Mutex
+	buckets *lru.Cache
+}
+
+func newLocalBuckets(size int) *localBuckets {
+	buckets, _ := lru.New(size)
+	return &localBuckets{buckets: buckets}
+}
+
+func (b *localBuckets) take(key string, rate float64, burst int, cost int) time.Duration {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	now := time.Now()
+	var bucket *tokenBucket
+	if value, ok := b.buckets.Get(key); ok {
+		bucket = value.(*tokenBucket)
+	} else {
+		bucket = &tokenBucket{tokens: float64(burst), ts: now}
+		b.buckets.Add(key, bucket)
+	}
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.ts).Seconds()*rate)
+	bucket.ts = now
+
+	var wait time.Duration
+	if bucket.tokens >= float64(cost) {
+		bucket.tokens -= float64(cost)
+	} else {
+		wait = time.Duration((float64(cost) - bucket.tokens) / rate * float64(time.Second))
+	}
+	return wait
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/rpc/namespaces/eth"
+)
+
+func newTestLimitedRouter(t *testing.T, config *Config, redisCli redis.Cmdable, trustedProxies []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.POST("/", newRateLimiter(config, redisCli).middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return router
+}
+
+func batchOf(n int) string {
+	calls := make([]string, n)
+	for i := range calls {
+		calls[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_blockNumber"}`, i+1)
+	}
+	return "[" + strings.Join(calls, ",") + "]"
+}
+
+func postFrom(router http.Handler, remoteAddr string, forwardedFor string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRateLimitRejectsBatchOverBurst(t *testing.T) {
+	router := newTestLimitedRouter(t, &Config{RateLimit: 2}, nil, nil)
+
+	recorder := postFrom(router, "10.0.0.1:1234", "", batchOf(3))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want the batch over the burst rejected", recorder.Code)
+	}
+	resps, _, err := parseMessages(recorder.Body.Bytes())
+	if err != nil || len(resps) != 3 {
+		t.Fatalf("responses %s: %v", recorder.Body.String(), err)
+	}
+	for _, resp := range resps {
+		if resp.Error == nil || resp.Error.Code != eth.LimitExceededErrorCode {
+			t.Fatalf("response %+v, want limit exceeded", resp)
+		}
+	}
+
+	// the rejected batch takes no tokens
+	if recorder := postFrom(router, "10.0.0.1:1234", "", batchOf(2)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %d of a batch within the burst", recorder.Code)
+	}
+	if recorder := postFrom(router, "10.0.0.1:1234", "", batchOf(1)); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want the bucket drained by the batch", recorder.Code)
+	}
+}
+
+func TestRateLimitSharedInRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two replicas of the service share the buckets
+	config := &Config{RateLimit: 2, RateLimitRedis: true}
+	router1 := newTestLimitedRouter(t, config, redisCli, nil)
+	router2 := newTestLimitedRouter(t, config, redisCli, nil)
+
+	if recorder := postFrom(router1, "10.0.0.1:1234", "", batchOf(2)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %d of a batch within the burst", recorder.Code)
+	}
+	if recorder := postFrom(router2, "10.0.0.1:1234", "", batchOf(1)); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want the bucket drained on the other replica", recorder.Code)
+	}
+	if recorder := postFrom(router2, "10.0.0.2:1234", "", batchOf(1)); recorder.Code != http.StatusOK {
+		t.Fatalf("status %d of another caller", recorder.Code)
+	}
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		limited        bool // whether forging X-Forwarded-For is still limited
+	}{
+		{name: "no trusted proxy", limited: true},
+		{name: "other proxy", trustedProxies: []string{"10.0.1.0/24"}, limited: true},
+		{name: "trusted proxy", trustedProxies: []string{"10.0.0.0/24"}, limited: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestLimitedRouter(t, &Config{RateLimit: 1}, nil, tt.trustedProxies)
+			if recorder := postFrom(router, "10.0.0.1:1234", "1.1.1.1", batchOf(1)); recorder.Code != http.StatusOK {
+				t.Fatalf("status %d of the first request", recorder.Code)
+			}
+			recorder := postFrom(router, "10.0.0.1:1234", "2.2.2.2", batchOf(1))
+			if limited := recorder.Code == http.StatusTooManyRequests; limited != tt.limited {
+				t.Fatalf("status %d, want limited %v", recorder.Code, tt.limited)
+			}
+		})
+	}
+}
+
+func TestLocalBucketsCap(t *testing.T) {
+	buckets := newLocalBuckets(2)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if wait := buckets.take(key, 1, 1, 1); wait != 0 {
+			t.Fatalf("%s waits %s on a new bucket", key, wait)
+		}
+	}
+	if n := buckets.buckets.Len(); n != 2 {
+		t.Fatalf("kept %d buckets, want 2", n)
+	}
+	// d is still drained, a is evicted
+	if wait := buckets.take("d", 1, 1, 1); wait == 0 {
+		t.Fatal("d is not limited")
+	}
+	if !buckets.buckets.Contains("d") || buckets.buckets.Contains("a") {
+		t.Fatalf("kept %v, want the most recently used", buckets.buckets.Keys())
+	}
+}
+
+// logsService serves eth_getLogs of the logs class
+type logsService struct{}
+
+func (logsService) GetLogs() []string {
+	return []string{}
+}
+
+func TestRateLimitWebsocket(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("test", echoService{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("eth", logsService{}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	limiter := newRateLimiter(&Config{RateLimit: 5, RateLimitLogs: 1}, nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", limiter.middleware(), gin.WrapH(websocketHandler(server, nil, limiter)))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	call := func(id int, method string) *jsonError {
+		params := []string{}
+		if method == "test_echo" {
+			params = []string{"hi"}
+		}
+		if err := conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+			t.Fatal(err)
+		}
+		var resp jsonrpcMessage
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.ID) != strconv.Itoa(id) {
+			t.Fatalf("response of call %s, want %d", resp.ID, id)
+		}
+		return resp.Error
+	}
+
+	// the logs class is limited on the socket as over http
+	if e := call(1, "eth_getLogs"); e != nil {
+		t.Fatalf("first eth_getLogs failed: %+v", e)
+	}
+	if e := call(2, "eth_getLogs"); e == nil || e.Code != eth.LimitExceededErrorCode {
+		t.Fatalf("second eth_getLogs %+v, want limit exceeded", e)
+	}
+	// the other calls take the default bucket until it is empty
+	for id := 3; ; id++ {
+		e := call(id, "test_echo")
+		if e == nil {
+			if id > 3+5 {
+				t.Fatalf("%d calls passed the rate limit of 5", id-2)
+			}
+			continue
+		}
+		if e.Code != eth.LimitExceededErrorCode {
+			t.Fatalf("call %d failed: %+v", id, e)
+		}
+		break
+	}
+}
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/logger"
+)
+
+// ConfigSource provides the remote configuration of the service and notifies its changes
+type ConfigSource interface {
+	Get() (string, error)
+	Listen(onChange func(content string)) error
+}
+
+// ConfigParser builds the service config from the content of a config source
+type ConfigParser func(content string) (*Config, error)
+
+// Watch listens the config source and reloads the service on every change
+func (s *Service) Watch(source ConfigSource, parse ConfigParser) error {
+	return source.Listen(func(content string) {
+		config, err := parse(content)
+		if err != nil {
+			log.Error("failed to parse the changed config", "err", err)
+			return
+		}
+		if err := s.Reload(config); err != nil {
+			log.Error("failed to reload config", "err", err)
+		}
+	})
+}
+
+// Reload applies the values which are safe to change at runtime, the others are kept
+// until the service restarts
+func (s *Service) Reload(config *Config) error {
+	if err := ValidateConfig(config); err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	old := s.config
+	merged := *old
+	merged.UpstreamUrl = config.UpstreamUrl
+	merged.NacosWeight = config.NacosWeight
+	merged.MaxIndexerLag = config.MaxIndexerLag
+	merged.ShutdownDelay = config.ShutdownDelay
+	merged.ShutdownTimeout = config.ShutdownTimeout
+	merged.RateLimit = config.RateLimit
+	merged.RateLimitLogs = config.RateLimitLogs
+	merged.APIKeys = config.APIKeys
+	merged.APIKeyRequired = config.APIKeyRequired
+	merged.LogLevel = config.LogLevel
+	merged.SlowQueryThreshold = config.SlowQueryThreshold
+	s.config = &merged
+	registrar := s.registrar
+	s.mtx.Unlock()
+
+	if merged != *config {
+		log.Warn("config changes other than upstream, nacos weight, indexer lag, shutdown, rate limits, api keys, log level and slow query threshold are applied after restart")
+	}
+	s.handler.setUpstream(merged.UpstreamUrl)
+	s.health.setMaxLag(merged.MaxIndexerLag)
+	s.limiter.setLimits(&merged)
+	if err := logger.Init(merged.LogLevel, merged.LogFormat, merged.SlowQueryThreshold); err != nil {
+		return err
+	}
+	if registrar != nil && merged.NacosWeight != old.NacosWeight {
+		if err := registrar.UpdateWeight(merged.NacosWeight); err != nil {
+			return err
+		}
+	}
+	log.Info("config reloaded")
+	return nil
+}
@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+)
+
+// fakeConfigSource delivers the changes pushed by the test to the listener
+type fakeConfigSource struct {
+	content  string
+	onChange func(content string)
+}
+
+func (s *fakeConfigSource) Get() (string, error) {
+	return s.content, nil
+}
+
+func (s *fakeConfigSource) Listen(onChange func(content string)) error {
+	s.onChange = onChange
+	return nil
+}
+
+func (s *fakeConfigSource) push(content string) {
+	s.content = content
+	s.onChange(content)
+}
+
+// logRecorder keeps the records of the root logger, it must be installed again after a reload
+// as the reload initializes the logger
+type logRecorder struct {
+	mtx     sync.Mutex
+	records []*log.Record
+}
+
+func (r *logRecorder) install() {
+	log.Root().SetHandler(log.FuncHandler(func(record *log.Record) error {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		r.records = append(r.records, record)
+		return nil
+	}))
+}
+
+// has reports whether a record of the level contains the message
+func (r *logRecorder) has(lvl log.Lvl, msg string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, record := range r.records {
+		if record.Lvl == lvl && strings.Contains(record.Msg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func testConfig() *Config {
+	return &Config{
+		Profile:         ProfileDev,
+		Address:         "127.0.0.1:8545",
+		DBDriver:        store.DriverSQLite,
+		MysqlDB:         "infura.db",
+		RedisMode:       redis.ModeStandalone,
+		RedisUrl:        "127.0.0.1:6379",
+		ChainID:         66,
+		MaxIndexerLag:   time.Minute,
+		RateLimit:       10,
+		LogLevel:        "info",
+		LogFormat:       "json",
+		ShutdownTimeout: time.Second,
+	}
+}
+
+func newTestReloadService(t *testing.T, config *Config) (*Service, *fakeConfigSource) {
+	s := &Service{
+		config:  config,
+		handler: newFallbackHandler(rpc.NewServer(), config.UpstreamUrl, nil),
+		health:  newHealthChecker(nil, nil, config.MaxIndexerLag),
+		limiter: newRateLimiter(config, nil),
+	}
+	source := &fakeConfigSource{}
+	// the changes are applied on top of the current config, so that a change is a partial json
+	parse := func(content string) (*Config, error) {
+		changed := *s.currentConfig()
+		if err := json.Unmarshal([]byte(content), &changed); err != nil {
+			return nil, err
+		}
+		return &changed, nil
+	}
+	if err := s.Watch(source, parse); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Root().SetHandler(log.DiscardHandler()) })
+	return s, source
+}
+
+func TestReloadAppliesSafeFields(t *testing.T) {
+	s, source := newTestReloadService(t, testConfig())
+	logs := &logRecorder{}
+	logs.install()
+
+	source.push(`{"UpstreamUrl":"http://node:8545","MaxIndexerLag":120000000000,"RateLimit":5,"RateLimitLogs":1,"APIKeys":"a,b","APIKeyRequired":true}`)
+
+	config := s.currentConfig()
+	if config.UpstreamUrl != "http://node:8545" || config.RateLimit != 5 || config.APIKeys != "a,b" {
+		t.Fatalf("config is not reloaded: %+v", config)
+	}
+	if upstream := s.handler.upstream.Load().(string); upstream != "http://node:8545" {
+		t.Fatalf("upstream is %q", upstream)
+	}
+	if maxLag := atomic.LoadInt64(&s.health.maxLag); time.Duration(maxLag) != 2*time.Minute {
+		t.Fatalf("max lag is %s", time.Duration(maxLag))
+	}
+	limits := s.limiter.limits.Load().(map[string]float64)
+	if limits[methodClassDefault] != 5 || limits[methodClassLogs] != 1 {
+		t.Fatalf("limits are %v", limits)
+	}
+	if !s.limiter.validAPIKey("a") || !s.limiter.validAPIKey("b") || s.limiter.validAPIKey("c") ||
+		atomic.LoadInt32(&s.limiter.keyRequired) != 1 {
+		t.Fatal("api keys are not reloaded")
+	}
+	if logs.has(log.LvlWarn, "applied after restart") {
+		t.Fatal("warned about safe fields")
+	}
+}
+
+func TestReloadIgnoresUnsafeFields(t *testing.T) {
+	s, source := newTestReloadService(t, testConfig())
+	logs := &logRecorder{}
+	logs.install()
+
+	source.push(`{"Address":"127.0.0.1:9545","MysqlDB":"other.db","RateLimit":5}`)
+
+	config := s.currentConfig()
+	if config.Address != "127.0.0.1:8545" || config.MysqlDB != "infura.db" {
+		t.Fatalf("unsafe fields are applied: %+v", config)
+	}
+	if config.RateLimit != 5 {
+		t.Fatalf("rate limit is %v, want the safe field applied", config.RateLimit)
+	}
+	if !logs.has(log.LvlWarn, "applied after restart") {
+		t.Fatal("no warning about the unsafe fields")
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	s, source := newTestReloadService(t, testConfig())
+
+	for _, content := range []string{
+		`{"RateLimit":-1}`,
+		`{"UpstreamUrl":"ftp://node"}`,
+		`{"LogLevel":"loud"}`,
+		`not json`,
+	} {
+		logs := &logRecorder{}
+		logs.install()
+		source.push(content)
+
+		config := s.currentConfig()
+		if config.RateLimit != 10 || config.UpstreamUrl != "" || config.LogLevel != "info" {
+			t.Fatalf("%s: invalid config is applied: %+v", content, config)
+		}
+		if !logs.has(log.LvlError, "config") {
+			t.Fatalf("%s: no error is logged", content)
+		}
+	}
+	if err := s.Reload(&Config{}); err == nil {
+		t.Fatal("empty config is reloaded")
+	}
+}
@@ -3,90 +3,214 @@ package rpc
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/metrics"
 	"github.com/okex/infura-service/nacos"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+	"github.com/okex/infura-service/tracing"
 
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	defaultServiceName  = "infura-service"
+	tracingFlushTimeout = 5 * time.Second
+)
+
 type Service struct {
-	config *Config
-	router *gin.Engine
-	ethRPC *rpc.Server
+	mtx       sync.RWMutex
+	config    *Config
+	router    *gin.Engine
+	ethRPC    *rpc.Server
+	handler   *fallbackHandler
+	health    *healthChecker
+	limiter   *rateLimiter
+	registrar *nacos.Registrar
+	orm       store.Backend
+	// flushes the pending spans on shutdown
+	shutdownTracing func(context.Context) error
 }
 
 func New(config *Config) (*Service, error) {
-	if err := validateConfig(config); err != nil {
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
+	}
+	if err := logger.Init(config.LogLevel, config.LogFormat, config.SlowQueryThreshold); err != nil {
+		return nil, err
+	}
+	serviceName := config.NacosServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	shutdownTracing, err := tracing.Init(config.TraceEndpoint, serviceName, config.TraceSampleRatio)
+	if err != nil {
 		return nil, err
 	}
 	// gin api
-	gin.SetMode(gin.DebugMode)
-	router := gin.Default()
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	// the client ip of the rate limits is only taken from X-Forwarded-For of the trusted proxies
+	if err := router.SetTrustedProxies(splitList(config.TrustedProxies)); err != nil {
+		return nil, err
+	}
+	router.Use(traceRequest(), requestID(), accessLog(), recovery())
+
+	orm, err := store.Open(config.DBDriver, config.DBConfig())
+	if err != nil {
+		return nil, err
+	}
+	orm = store.WithMetrics(orm)
+	redisCli, err := redis.NewClient(config.RedisConfig())
+	if err != nil {
+		return nil, err
+	}
+	if config.CacheSize > 0 || config.CacheTTL > 0 {
+		orm, err = store.WithCache(orm, redisCli, latestBlock(redisCli), store.CacheConfig{
+			Size:          config.CacheSize,
+			TTL:           config.CacheTTL,
+			FinalityDepth: config.FinalityDepth,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	metrics.RegisterIndexerLag(indexerLagGauge(indexerLag(orm, redisCli)))
 
 	// eth rpc server
 	ethRPC := rpc.NewServer()
-	apis := getAPIs(config)
+	apis := getAPIs(config, orm, redisCli)
 	for _, api := range apis {
 		if err := ethRPC.RegisterName(api.Namespace, api.Service); err != nil {
 			panic(err)
 		}
 	}
+	// label the metrics of the served and forwarded methods only
+	var names []string
+	for name := range methodNames(apis) {
+		names = append(names, name)
+	}
+	for name := range forwardMethods {
+		names = append(names, name)
+	}
+	metrics.RegisterMethods(names...)
 	return &Service{
 		config: config,
 		router: router,
 		ethRPC: ethRPC,
+		// forward unsupported methods and missing data to the upstream node
+		handler: newFallbackHandler(ethRPC, config.UpstreamUrl, apis),
+		health:  newHealthChecker(orm, redisCli, config.MaxIndexerLag),
+		limiter: newRateLimiter(config, redisCli),
+		orm:     orm,
+
+		shutdownTracing: shutdownTracing,
 	}, nil
 }
 
 func (s *Service) Start() {
-	// register rpc service to nacos
-	if s.config.NacosUrl != "" {
-		nacos.Register(s.config.NacosUrl, s.config.NacosNamespaceId, s.config.NacosServiceName, s.config.NacosServiceAddr)
-	}
+	config := s.currentConfig()
 
 	// register http router
 	s.registerRoutes()
 
 	// http server
 	srv := &http.Server{
-		Addr:    s.config.Address,
+		Addr:    config.Address,
 		Handler: s.router,
 	}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
-			log.Printf("listen: %s\n", err)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Crit("failed to listen", "address", config.Address, "err", err)
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	// register rpc service to nacos
+	stopWatch := make(chan struct{})
+	if config.NacosUrl != "" {
+		registrar, err := nacos.NewRegistrar(config.NacosUrl, config.NacosNamespaceId,
+			config.NacosServiceName, config.NacosServiceAddr, config.NacosWeight)
+		if err != nil {
+			log.Crit("failed to create nacos registrar", "err", err)
+		}
+		s.mtx.Lock()
+		s.registrar = registrar
+		s.mtx.Unlock()
+		if err := registrar.Register(); err != nil {
+			log.Crit("failed to register in nacos", "err", err)
+		}
+		go s.watchReadiness(registrar, stopWatch)
+	}
+
+	// Wait for interrupt signal to gracefully shutdown the server
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	log.Info("shutting down server")
+	s.health.setShuttingDown()
+
+	// deregister first, and keep serving until the gateways stop routing requests to this instance
+	config = s.currentConfig()
+	if registrar := s.currentRegistrar(); registrar != nil {
+		close(stopWatch)
+		if err := registrar.Close(); err != nil {
+			log.Error("failed to deregister from nacos", "err", err)
+		}
+	}
+	time.Sleep(config.ShutdownDelay)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// drain the in-flight requests and stop the http server
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		log.Error("server forced to shutdown", "err", err)
+	}
+	if err := s.orm.Close(); err != nil {
+		log.Error("failed to close the database", "err", err)
+	}
+	// the drain may have used up the timeout, flush the spans with their own
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancelFlush()
+	if err := s.shutdownTracing(flushCtx); err != nil {
+		log.Error("failed to flush spans", "err", err)
 	}
 
-	log.Println("Server exiting")
+	log.Info("server exiting")
+}
+
+func (s *Service) currentConfig() *Config {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.config
+}
+
+func (s *Service) currentRegistrar() *nacos.Registrar {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.registrar
 }
 
 func (s *Service) registerRoutes() {
-	s.router.POST("/", func(c *gin.Context) {
-		s.ethRPC.ServeHTTP(c.Writer, c.Request)
-	})
-	s.router.OPTIONS("/", func(c *gin.Context) {
-		s.ethRPC.ServeHTTP(c.Writer, c.Request)
-	})
+	s.router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	s.router.GET("/healthz", s.health.healthz)
+	s.router.GET("/readyz", s.health.readyz)
+	rpcHandler := func(c *gin.Context) {
+		s.handler.ServeHTTP(c.Writer, c.Request)
+	}
+	s.router.POST("/", s.limiter.middleware(), rpcMetrics(), rpcHandler)
+	s.router.POST("/:"+apiKeyParam, s.limiter.middleware(), rpcMetrics(), rpcHandler)
+	s.router.OPTIONS("/", rpcHandler)
+	wsHandler := websocketHandler(s.ethRPC, splitList(s.currentConfig().WSOrigins), s.limiter)
+	s.router.GET("/ws", s.limiter.middleware(), gin.WrapH(wsHandler))
+	s.router.GET("/ws/:"+apiKeyParam, s.limiter.middleware(), gin.WrapH(wsHandler))
 }
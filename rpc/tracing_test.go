@@ -0,0 +1,134 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gin-gonic/gin"
+	"github.com/okex/infura-service/migration"
+	"github.com/okex/infura-service/redis"
+	"github.com/okex/infura-service/store"
+	"github.com/okex/infura-service/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerRecorder keeps the traceparent header of the requests to the upstream
+type headerRecorder struct {
+	mtx         sync.Mutex
+	traceparent []string
+	next        http.Handler
+}
+
+func (h *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	h.traceparent = append(h.traceparent, r.Header.Get("traceparent"))
+	h.mtx.Unlock()
+	h.next.ServeHTTP(w, r)
+}
+
+func TestTracingSpanTree(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	if _, err := tracing.Init("", "infura-test", 1); err != nil {
+		t.Fatal(err)
+	}
+	provider := tracing.Setup(sdktrace.NewSimpleSpanProcessor(exporter), "infura-test", 1)
+	defer func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}()
+
+	orm, err := store.OpenOrm(store.DriverSQLite, store.Config{DBName: filepath.Join(t.TempDir(), "infura.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migration.NewMigrator(orm.DB()).Up(0); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	mr.Set("infura_latest_task", `{"height":10}`)
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apis := getAPIs(testConfig(), orm, redisCli)
+	local := rpc.NewServer()
+	for _, api := range apis {
+		if err := local.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upstream := &headerRecorder{next: &upstreamStub{}}
+	node := httptest.NewServer(upstream)
+	defer node.Close()
+	handler := newFallbackHandler(local, node.URL, apis)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(traceRequest(), requestID())
+	router.POST("/", gin.WrapH(handler))
+
+	// block 5 is not indexed, so the call falls back to the upstream
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":["0x5",false]},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`))
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	traceID := "0af7651916cd43dd8448eb211c80319c"
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID().String() == traceID {
+			spans[span.Name] = span
+		}
+	}
+	parentOf := func(name string) string {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no span %s in %v", name, spans)
+		}
+		for parent, s := range spans {
+			if s.SpanContext.SpanID() == span.Parent.SpanID() {
+				return parent
+			}
+		}
+		return span.Parent.SpanID().String()
+	}
+
+	// the request continues the trace of the gateway
+	if parent := parentOf("POST /"); parent != "b7ad6b7169203331" {
+		t.Fatalf("http span has parent %s", parent)
+	}
+	for _, call := range []string{"eth_getBlockByNumber", "eth_blockNumber", "upstream"} {
+		if parent := parentOf(call); parent != "POST /" {
+			t.Fatalf("%s span has parent %s, want the http span", call, parent)
+		}
+	}
+	if parent := parentOf("query blocks"); parent != "eth_getBlockByNumber" {
+		t.Fatalf("sql span has parent %s", parent)
+	}
+	if parent := parentOf("redis get"); parent != "eth_getBlockByNumber" && parent != "eth_blockNumber" {
+		t.Fatalf("redis span has parent %s", parent)
+	}
+
+	upstream.mtx.Lock()
+	defer upstream.mtx.Unlock()
+	want := "00-" + traceID + "-" + spans["upstream"].SpanContext.SpanID().String() + "-01"
+	if len(upstream.traceparent) != 1 || upstream.traceparent[0] != want {
+		t.Fatalf("upstream traceparent %v, want %s", upstream.traceparent, want)
+	}
+}
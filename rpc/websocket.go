@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/rpc/namespaces/eth"
+)
+
+// same as rpc.Server.WebsocketHandler
+const (
+	wsBufferSize       = 1024
+	wsPingInterval     = 60 * time.Second
+	wsPingWriteTimeout = 5 * time.Second
+	wsMessageSizeLimit = 15 * 1024 * 1024
+)
+
+// wsReadTimeout closes the connections that neither send a message nor answer the pings
+var wsReadTimeout = wsPingInterval + 30*time.Second
+
+// websocketHandler serves json-rpc to the websocket connections of the allowed origins like
+// rpc.Server.WebsocketHandler. The rpc server runs the calls of a connection without the
+// request context, so the request id of the upgrade is returned in the handshake and
+// logged with every call received on the connection. If the limiter is set, the calls
+// take the tokens of the caller of the upgrade as they are read, and the calls over the
+// limits are answered without being served.
+func websocketHandler(server *rpc.Server, origins []string, limiter *rateLimiter) http.Handler {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  wsBufferSize,
+		WriteBufferSize: wsBufferSize,
+		CheckOrigin:     checkOrigin(origins),
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := logger.FromContext(r.Context())
+		header := http.Header{}
+		if id := logger.RequestID(r.Context()); id != "" {
+			header.Set(requestIDHeader, id)
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			l.Debug("websocket upgrade failed", "err", err)
+			return
+		}
+		conn.SetReadLimit(wsMessageSizeLimit)
+		conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		})
+
+		// the rate limit errors are written along with the responses of the rpc server
+		var writeMtx sync.Mutex
+		write := func(v interface{}) error {
+			writeMtx.Lock()
+			defer writeMtx.Unlock()
+			return conn.WriteJSON(v)
+		}
+		caller, limited := callerOf(r.Context())
+		limited = limited && limiter != nil
+		decode := func(v interface{}) error {
+			for {
+				if err := conn.ReadJSON(v); err != nil {
+					return err
+				}
+				conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+				raw, ok := v.(*json.RawMessage)
+				if !ok {
+					return nil
+				}
+				msgs, batch, err := parseMessages(*raw)
+				if err != nil {
+					// the rpc server answers the invalid message
+					return nil
+				}
+				logCalls(l, msgs)
+				if !limited {
+					return nil
+				}
+				e := limiter.limit(r.Context(), caller, msgs)
+				if e == nil {
+					return nil
+				}
+				resps := errorMessages(msgs, eth.LimitExceededErrorCode, e.message, e.data())
+				if len(resps) == 0 {
+					continue
+				}
+				if batch {
+					err = write(resps)
+				} else {
+					err = write(resps[0])
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go pingLoop(conn, done)
+		// 阻塞直到连接关闭
+		server.ServeCodec(rpc.NewFuncCodec(conn, write, decode), 0)
+	})
+}
+
+// checkOrigin allows the given origins, any origin with "*". The requests without
+// origin are not from browsers and always allowed.
+func checkOrigin(origins []string) func(*http.Request) bool {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[strings.ToLower(origin)] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowed["*"] || allowed[strings.ToLower(origin)]
+	}
+}
+
+// logCalls logs the methods of the calls in the websocket message
+func logCalls(l log.Logger, msgs []*jsonrpcMessage) {
+	var methods []string
+	for _, msg := range msgs {
+		if msg != nil && msg.Method != "" {
+			methods = append(methods, msg.Method)
+		}
+	}
+	if len(methods) > 0 {
+		l.Info("websocket call", "rpc_methods", strings.Join(methods, ","))
+	}
+}
+
+// pingLoop pings the connection until done, so that the idle connections are kept open
+func pingLoop(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingWriteTimeout))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type echoService struct{}
+
+func (echoService) Echo(s string) string {
+	return s
+}
+
+func TestWebsocketRequestID(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("test", echoService{}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestID())
+	router.GET("/ws", gin.WrapH(websocketHandler(server, []string{"*"}, nil)))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	logs := &logRecorder{}
+	logs.install()
+	defer log.Root().SetHandler(log.DiscardHandler())
+
+	header := http.Header{}
+	header.Set(requestIDHeader, "ws-1")
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if id := resp.Header.Get(requestIDHeader); id != "ws-1" {
+		t.Fatalf("handshake request id %q, want ws-1", id)
+	}
+
+	if err := conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "test_echo", "params": []string{"hi"}}); err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Result string `json:"result"`
+	}
+	if err := conn.ReadJSON(&reply); err != nil || reply.Result != "hi" {
+		t.Fatalf("reply %+v %v", reply, err)
+	}
+
+	logs.mtx.Lock()
+	defer logs.mtx.Unlock()
+	for _, record := range logs.records {
+		if record.Msg != "websocket call" {
+			continue
+		}
+		fields := map[interface{}]interface{}{}
+		for i := 0; i+1 < len(record.Ctx); i += 2 {
+			fields[record.Ctx[i]] = record.Ctx[i+1]
+		}
+		if fields["request_id"] != "ws-1" || fields["rpc_methods"] != "test_echo" {
+			t.Fatalf("call logged with %v", record.Ctx)
+		}
+		return
+	}
+	t.Fatal("websocket call is not logged")
+}
+
+func newTestWebsocketServer(t *testing.T, origins []string) *httptest.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("test", echoService{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", gin.WrapH(websocketHandler(server, origins, nil)))
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	ts := newTestWebsocketServer(t, []string{"https://app.example.com"})
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	for origin, allowed := range map[string]bool{
+		"":                         true, // not a browser
+		"https://app.example.com":  true,
+		"https://APP.example.com":  true,
+		"https://evil.example.com": false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if allowed && err != nil {
+			t.Fatalf("origin %q rejected: %v", origin, err)
+		}
+		if !allowed && (err == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Fatalf("origin %q is not forbidden: %v", origin, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestWebsocketIdleTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { wsReadTimeout = timeout }(wsReadTimeout)
+	wsReadTimeout = 200 * time.Millisecond
+	ts := newTestWebsocketServer(t, nil)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the idle connection is closed by the server
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Fatalf("read %v, want the connection closed by the server", err)
+	}
+}
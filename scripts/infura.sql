@@ -13,11 +13,12 @@ CREATE TABLE `blocks` (
                           `gas_limit` bigint(20) unsigned DEFAULT NULL,
                           `gas_used` bigint(20) unsigned DEFAULT NULL,
                           `timestamp` int(11) DEFAULT NULL,
+                          `logs_bloom` varchar(514) DEFAULT NULL,
                           PRIMARY KEY (`id`),
                           UNIQUE KEY `unique_hash` (`hash`),
                           KEY `idx_blocks_deleted_at` (`deleted_at`),
                           KEY `idx_blocks_number` (`number`)
-) ENGINE=InnoDB AUTO_INCREMENT=3534 DEFAULT CHARSET=utf8mb4;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `contract_codes` (
                                   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -30,7 +31,7 @@ CREATE TABLE `contract_codes` (
                                   PRIMARY KEY (`id`),
                                   UNIQUE KEY `unique_address` (`address`),
                                   KEY `idx_contract_codes_deleted_at` (`deleted_at`)
-) ENGINE=InnoDB AUTO_INCREMENT=2 DEFAULT CHARSET=utf8mb4;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `log_topics` (
                               `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -39,11 +40,18 @@ CREATE TABLE `log_topics` (
                               `deleted_at` datetime(3) DEFAULT NULL,
                               `topic` varchar(66) DEFAULT NULL,
                               `transaction_log_id` bigint(20) unsigned DEFAULT NULL,
+                              `position` int(11) DEFAULT NULL,
                               PRIMARY KEY (`id`),
                               KEY `idx_log_topics_deleted_at` (`deleted_at`),
+                              KEY `idx_log_topics_topic_position` (`topic`, `position`, `transaction_log_id`),
                               KEY `fk_transaction_logs_topics` (`transaction_log_id`),
                               CONSTRAINT `fk_transaction_logs_topics` FOREIGN KEY (`transaction_log_id`) REFERENCES `transaction_logs` (`id`)
-) ENGINE=InnoDB AUTO_INCREMENT=4 DEFAULT CHARSET=utf8mb4;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- position is the order of a topic in its log, filled on insert as the infura task does not know the column
+CREATE TRIGGER `log_topics_position` BEFORE INSERT ON `log_topics` FOR EACH ROW
+    SET NEW.`position` = (SELECT COUNT(*) FROM `log_topics` p
+        WHERE p.`transaction_log_id` = NEW.`transaction_log_id` AND p.`deleted_at` IS NULL);
 
 CREATE TABLE `transaction_logs` (
                                     `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -65,7 +73,7 @@ CREATE TABLE `transaction_logs` (
                                     KEY `idx_transaction_logs_block_number` (`block_number`),
                                     KEY `fk_transaction_receipts_logs` (`transaction_receipt_id`),
                                     CONSTRAINT `fk_transaction_receipts_logs` FOREIGN KEY (`transaction_receipt_id`) REFERENCES `transaction_receipts` (`id`)
-) ENGINE=InnoDB AUTO_INCREMENT=2 DEFAULT CHARSET=utf8mb4;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `transaction_receipts` (
                                         `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -84,8 +92,9 @@ CREATE TABLE `transaction_receipts` (
                                         `to` varchar(42) DEFAULT NULL,
                                         PRIMARY KEY (`id`),
                                         UNIQUE KEY `unique_hash` (`transaction_hash`),
-                                        KEY `idx_transaction_receipts_deleted_at` (`deleted_at`)
-) ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4;
+                                        KEY `idx_transaction_receipts_deleted_at` (`deleted_at`),
+                                        KEY `idx_transaction_receipts_block_hash` (`block_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE `transactions` (
                                 `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
@@ -109,6 +118,7 @@ CREATE TABLE `transactions` (
                                 `block_id` bigint(20) unsigned DEFAULT NULL,
                                 PRIMARY KEY (`id`),
                                 KEY `idx_transactions_deleted_at` (`deleted_at`),
+                                KEY `idx_transactions_hash` (`hash`),
                                 KEY `fk_blocks_transactions` (`block_id`),
                                 CONSTRAINT `fk_blocks_transactions` FOREIGN KEY (`block_id`) REFERENCES `blocks` (`id`)
-) ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
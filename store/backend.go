@@ -0,0 +1,28 @@
+package store
+
+import (
+	"context"
+
+	"github.com/okex/exchain/x/infura/types"
+)
+
+// Backend is the storage of the data synced by the infura task
+type Backend interface {
+	Ping(ctx context.Context) error
+	Close() error
+	GetTransactionReceipt(ctx context.Context, txHash string) ([]types.TransactionReceipt, error)
+	GetBlockReceipts(ctx context.Context, blockHash string) ([]types.TransactionReceipt, error)
+	GetTransactionByHash(ctx context.Context, txHash string) ([]types.Transaction, error)
+	GetLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, orphans []string, limit int) ([]types.TransactionLog, error)
+	GetFinalLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error)
+	GetLogsByBlockHash(ctx context.Context, blockHash string, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error)
+	GetBlockByNumber(ctx context.Context, blockNum int64) (types.Block, error)
+	GetEarliestBlockNumber(ctx context.Context) (int64, error)
+	GetBlocksByRange(ctx context.Context, fromBlock, toBlock int64) ([]types.Block, error)
+	GetBlockByHash(ctx context.Context, blockHash string) (types.Block, error)
+	GetContractCode(ctx context.Context, address string) (types.ContractCode, error)
+	GetBlockBloom(ctx context.Context, blockHash string) (string, error)
+	SaveBlockBloom(ctx context.Context, blockHash string, bloom string) error
+}
+
+var _ Backend = (*Orm)(nil)
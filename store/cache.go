@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/metrics"
+	"github.com/okex/infura-service/redis"
+)
+
+const (
+	cacheKeyPrefix     = "infura_cache_"
+	cacheGenerationKey = "infura_cache_generation"
+	cacheRefresh       = time.Second
+)
+
+// CacheConfig is the configuration of the read-through cache
+type CacheConfig struct {
+	// Size is the number of entries of the in-process lru, 0 to disable it
+	Size int
+	// TTL is the expiry of the entries in redis, 0 to disable the redis cache
+	TTL time.Duration
+	// FinalityDepth is the number of blocks behind the latest which may still change and are not cached
+	FinalityDepth int64
+}
+
+// Cache is a read-through cache of the immutable blocks, transactions, receipts and contract code,
+// kept in an in-process lru in front of redis. Data of blocks within the finality depth is not cached.
+// The keys contain a generation shared in redis, which is increased by Purge to drop all entries on reorg.
+type Cache struct {
+	Backend
+	redisCli    redis.Cmdable
+	lru         *lru.Cache
+	config      CacheConfig
+	latestBlock func() (int64, error)
+
+	generation int64
+	latest     int64
+}
+
+// WithCache wraps the backend with the cache, latestBlock returns the latest height of the infura task
+func WithCache(backend Backend, redisCli redis.Cmdable, latestBlock func() (int64, error), config CacheConfig) (*Cache, error) {
+	c := &Cache{
+		Backend:     backend,
+		redisCli:    redisCli,
+		config:      config,
+		latestBlock: latestBlock,
+	}
+	if config.Size > 0 {
+		var err error
+		if c.lru, err = lru.New(config.Size); err != nil {
+			return nil, err
+		}
+	}
+	c.refresh()
+	go c.loop()
+	return c, nil
+}
+
+func (c *Cache) loop() {
+	ticker := time.NewTicker(cacheRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.refresh()
+	}
+}
+
+// refresh follows the latest height and the generation changed by other replicas. The infura task
+// going back below the finalized height is handled as a reorg, the refresh is skipped if the latest
+// height can not be read, as purging is shared by all replicas.
+func (c *Cache) refresh() {
+	latest, err := c.latestBlock()
+	if err != nil {
+		log.Warn("failed to get latest block, skip the cache refresh", "err", err)
+		return
+	}
+	if prev := atomic.SwapInt64(&c.latest, latest); latest < prev-c.config.FinalityDepth {
+		log.Warn("latest block went back past the finality depth, purge the cache", "from", prev, "to", latest)
+		c.Purge()
+		return
+	}
+	value, err := c.redisCli.Get(context.Background(), cacheGenerationKey)
+	if err != nil {
+		return
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return
+	}
+	if atomic.SwapInt64(&c.generation, generation) != generation && c.lru != nil {
+		c.lru.Purge()
+	}
+}
+
+// Purge drops the cached entries of all replicas
+func (c *Cache) Purge() {
+	generation, err := c.redisCli.Incr(context.Background(), cacheGenerationKey)
+	if err != nil {
+		log.Error("failed to increase cache generation", "err", err)
+		generation = atomic.LoadInt64(&c.generation) + 1
+	}
+	atomic.StoreInt64(&c.generation, generation)
+	if c.lru != nil {
+		c.lru.Purge()
+	}
+}
+
+// Purge drops the cached entries if the backend is cached
+func Purge(backend Backend) {
+	if c, ok := backend.(*Cache); ok {
+		c.Purge()
+	}
+}
+
+// final reports whether the block can not change anymore
+func (c *Cache) final(height int64) bool {
+	return height <= atomic.LoadInt64(&c.latest)-c.config.FinalityDepth
+}
+
+// get looks up the key in the lru and then redis, and decodes the entry into value. On miss it
+// calls load to fill value, which is cached only if load reports it as final.
+func (c *Cache) get(ctx context.Context, method string, key string, value interface{}, load func() (bool, error)) error {
+	key = fmt.Sprintf("%s%d_%s", cacheKeyPrefix, atomic.LoadInt64(&c.generation), key)
+	if c.lru != nil {
+		data, ok := c.lru.Get(key)
+		metrics.ObserveCache("lru", method, ok)
+		if ok && json.Unmarshal(data.([]byte), value) == nil {
+			return nil
+		}
+	}
+	if c.config.TTL > 0 {
+		data, err := c.redisCli.Get(ctx, key)
+		metrics.ObserveCache("redis", method, err == nil)
+		if err == nil && json.Unmarshal([]byte(data), value) == nil {
+			if c.lru != nil {
+				c.lru.Add(key, []byte(data))
+			}
+			return nil
+		}
+	}
+
+	final, err := load()
+	if err != nil || !final {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	if c.lru != nil {
+		c.lru.Add(key, data)
+	}
+	if c.config.TTL > 0 {
+		if err := c.redisCli.Set(ctx, key, string(data), c.config.TTL); err != nil {
+			log.Warn("failed to save in cache", "key", key, "err", err)
+		}
+	}
+	return nil
+}
+
+func (c *Cache) GetTransactionReceipt(ctx context.Context, txHash string) (receipts []types.TransactionReceipt, err error) {
+	err = c.get(ctx, "GetTransactionReceipt", "receipt_"+txHash, &receipts, func() (bool, error) {
+		receipts, err = c.Backend.GetTransactionReceipt(ctx, txHash)
+		return len(receipts) > 0 && c.final(receipts[0].BlockNumber), err
+	})
+	return
+}
+
+func (c *Cache) GetBlockReceipts(ctx context.Context, blockHash string) (receipts []types.TransactionReceipt, err error) {
+	err = c.get(ctx, "GetBlockReceipts", "block_receipts_"+blockHash, &receipts, func() (bool, error) {
+		receipts, err = c.Backend.GetBlockReceipts(ctx, blockHash)
+		return len(receipts) > 0 && c.final(receipts[0].BlockNumber), err
+	})
+	return
+}
+
+func (c *Cache) GetTransactionByHash(ctx context.Context, txHash string) (transactions []types.Transaction, err error) {
+	err = c.get(ctx, "GetTransactionByHash", "transaction_"+txHash, &transactions, func() (bool, error) {
+		transactions, err = c.Backend.GetTransactionByHash(ctx, txHash)
+		return len(transactions) > 0 && c.final(transactions[0].BlockNumber), err
+	})
+	return
+}
+
+func (c *Cache) GetBlockByNumber(ctx context.Context, blockNum int64) (block types.Block, err error) {
+	if !c.final(blockNum) {
+		return c.Backend.GetBlockByNumber(ctx, blockNum)
+	}
+	err = c.get(ctx, "GetBlockByNumber", "block_"+strconv.FormatInt(blockNum, 10), &block, func() (bool, error) {
+		block, err = c.Backend.GetBlockByNumber(ctx, blockNum)
+		return true, err
+	})
+	return
+}
+
+func (c *Cache) GetBlockByHash(ctx context.Context, blockHash string) (block types.Block, err error) {
+	err = c.get(ctx, "GetBlockByHash", "block_"+blockHash, &block, func() (bool, error) {
+		block, err = c.Backend.GetBlockByHash(ctx, blockHash)
+		return c.final(block.Number), err
+	})
+	return
+}
+
+func (c *Cache) GetContractCode(ctx context.Context, address string) (code types.ContractCode, err error) {
+	err = c.get(ctx, "GetContractCode", "code_"+address, &code, func() (bool, error) {
+		code, err = c.Backend.GetContractCode(ctx, address)
+		return c.final(code.BlockNumber), err
+	})
+	return
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/okex/infura-service/redis"
+)
+
+// fakeLatest is the latest height of the infura task read by the cache, err fails the read
+type fakeLatest struct {
+	height int64
+	err    error
+}
+
+func (f *fakeLatest) get() (int64, error) {
+	return f.height, f.err
+}
+
+func newTestCache(t *testing.T, latest *fakeLatest, finalityDepth int64) (*Cache, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	redisCli, err := redis.NewClient(redis.Config{Addrs: []string{mr.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Cache{
+		redisCli:    redisCli,
+		config:      CacheConfig{FinalityDepth: finalityDepth},
+		latestBlock: latest.get,
+	}
+	c.refresh()
+	return c, mr
+}
+
+func generationOf(t *testing.T, mr *miniredis.Miniredis) string {
+	value, err := mr.Get(cacheGenerationKey)
+	if err != nil && !errors.Is(err, miniredis.ErrKeyNotFound) {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestCacheRefresh(t *testing.T) {
+	latest := &fakeLatest{height: 100}
+	c, mr := newTestCache(t, latest, 10)
+
+	// a failed read is not a reorg
+	latest.err = errors.New("i/o timeout")
+	c.refresh()
+	if generation := generationOf(t, mr); generation != "" {
+		t.Fatalf("purged on a failed read, generation %s", generation)
+	}
+	if !c.final(90) {
+		t.Fatal("latest is lost on a failed read")
+	}
+
+	// going back within the finality depth does not touch the cached blocks
+	latest.height, latest.err = 95, nil
+	c.refresh()
+	if generation := generationOf(t, mr); generation != "" {
+		t.Fatalf("purged within the finality depth, generation %s", generation)
+	}
+	if c.final(90) {
+		t.Fatal("latest is not followed back")
+	}
+
+	latest.height = 80
+	c.refresh()
+	if generation := generationOf(t, mr); generation != "1" {
+		t.Fatalf("generation %q, want purged past the finality depth", generation)
+	}
+}
+
+func TestCacheRefreshFollowsGeneration(t *testing.T) {
+	latest := &fakeLatest{height: 100}
+	c, mr := newTestCache(t, latest, 0)
+
+	// another replica purges the cache
+	mr.Set(cacheGenerationKey, "3")
+	c.refresh()
+	if c.generation != 3 {
+		t.Fatalf("generation %d, want 3", c.generation)
+	}
+}
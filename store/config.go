@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	drivermysql "github.com/go-sql-driver/mysql"
+)
+
+// Config is the connection config of the database, the password is masked when it is printed.
+// For sqlite DBName is the database file path.
+type Config struct {
+	Addr     string // host:port
+	User     string
+	Password string
+	DBName   string
+	SSLMode  string   // postgres only, empty for the default prefer of the driver
+	Replicas []string // host:port of the read replicas, with the same user, password and db
+	// ReplicaMaxLag is the number of blocks a replica may be behind the primary, 0 to disable the check
+	ReplicaMaxLag int64
+	Pool          PoolConfig
+}
+
+// PoolConfig is the connection pool of the primary and every replica, 0 for no limit
+// except MaxIdleConns, whose 0 keeps the default of database/sql
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (p PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("%s:******@%s/%s", c.User, c.Addr, c.DBName)
+}
+
+func (c Config) GoString() string {
+	return c.String()
+}
+
+// mysqlDSN returns the mysql dsn, the password is escaped by the driver
+func mysqlDSN(c Config) string {
+	dsn := drivermysql.NewConfig()
+	dsn.Net = "tcp"
+	dsn.Addr = c.Addr
+	dsn.User = c.User
+	dsn.Passwd = c.Password
+	dsn.DBName = c.DBName
+	dsn.Params = map[string]string{"charset": "utf8mb4"}
+	dsn.ParseTime = true
+	dsn.Loc = time.Local
+	return dsn.FormatDSN()
+}
+
+// postgresDSN returns the postgres dsn in url form, which escapes the password
+func postgresDSN(config Config) string {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(config.User, config.Password),
+		Host:   config.Addr,
+		Path:   "/" + config.DBName,
+	}
+	if config.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {config.SSLMode}}.Encode()
+	}
+	return dsn.String()
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/metrics"
+	"gorm.io/gorm"
+)
+
+// metricsBackend records the latency and row count of every query of the backend
+type metricsBackend struct {
+	backend Backend
+}
+
+// WithMetrics wraps the backend with prometheus metrics
+func WithMetrics(backend Backend) Backend {
+	return &metricsBackend{
+		backend: backend,
+	}
+}
+
+func (b *metricsBackend) Ping(ctx context.Context) error {
+	return b.backend.Ping(ctx)
+}
+
+func (b *metricsBackend) Close() error {
+	return b.backend.Close()
+}
+
+func (b *metricsBackend) GetTransactionReceipt(ctx context.Context, txHash string) ([]types.TransactionReceipt, error) {
+	start := time.Now()
+	receipts, err := b.backend.GetTransactionReceipt(ctx, txHash)
+	observeQuery("GetTransactionReceipt", start, len(receipts), err)
+	return receipts, err
+}
+
+func (b *metricsBackend) GetBlockReceipts(ctx context.Context, blockHash string) ([]types.TransactionReceipt, error) {
+	start := time.Now()
+	receipts, err := b.backend.GetBlockReceipts(ctx, blockHash)
+	observeQuery("GetBlockReceipts", start, len(receipts), err)
+	return receipts, err
+}
+
+func (b *metricsBackend) GetTransactionByHash(ctx context.Context, txHash string) ([]types.Transaction, error) {
+	start := time.Now()
+	transactions, err := b.backend.GetTransactionByHash(ctx, txHash)
+	observeQuery("GetTransactionByHash", start, len(transactions), err)
+	return transactions, err
+}
+
+func (b *metricsBackend) GetLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, orphans []string, limit int) ([]types.TransactionLog, error) {
+	start := time.Now()
+	logs, err := b.backend.GetLogs(ctx, fromBlock, toBlock, addresses, topics, orphans, limit)
+	observeQuery("GetLogs", start, len(logs), err)
+	return logs, err
+}
+
+func (b *metricsBackend) GetFinalLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error) {
+	start := time.Now()
+	logs, err := b.backend.GetFinalLogs(ctx, fromBlock, toBlock, addresses, topics, limit)
+	observeQuery("GetFinalLogs", start, len(logs), err)
+	return logs, err
+}
+
+func (b *metricsBackend) GetLogsByBlockHash(ctx context.Context, blockHash string, addresses []string, topics [][]string, limit int) ([]types.TransactionLog, error) {
+	start := time.Now()
+	logs, err := b.backend.GetLogsByBlockHash(ctx, blockHash, addresses, topics, limit)
+	observeQuery("GetLogsByBlockHash", start, len(logs), err)
+	return logs, err
+}
+
+func (b *metricsBackend) GetBlockByNumber(ctx context.Context, blockNum int64) (types.Block, error) {
+	start := time.Now()
+	block, err := b.backend.GetBlockByNumber(ctx, blockNum)
+	observeQuery("GetBlockByNumber", start, 1, err)
+	return block, err
+}
+
+func (b *metricsBackend) GetEarliestBlockNumber(ctx context.Context) (int64, error) {
+	start := time.Now()
+	number, err := b.backend.GetEarliestBlockNumber(ctx)
+	observeQuery("GetEarliestBlockNumber", start, 1, err)
+	return number, err
+}
+
+func (b *metricsBackend) GetBlocksByRange(ctx context.Context, fromBlock, toBlock int64) ([]types.Block, error) {
+	start := time.Now()
+	blocks, err := b.backend.GetBlocksByRange(ctx, fromBlock, toBlock)
+	observeQuery("GetBlocksByRange", start, len(blocks), err)
+	return blocks, err
+}
+
+func (b *metricsBackend) GetBlockByHash(ctx context.Context, blockHash string) (types.Block, error) {
+	start := time.Now()
+	block, err := b.backend.GetBlockByHash(ctx, blockHash)
+	observeQuery("GetBlockByHash", start, 1, err)
+	return block, err
+}
+
+func (b *metricsBackend) GetContractCode(ctx context.Context, address string) (types.ContractCode, error) {
+	start := time.Now()
+	code, err := b.backend.GetContractCode(ctx, address)
+	observeQuery("GetContractCode", start, 1, err)
+	return code, err
+}
+
+func (b *metricsBackend) GetBlockBloom(ctx context.Context, blockHash string) (string, error) {
+	start := time.Now()
+	bloom, err := b.backend.GetBlockBloom(ctx, blockHash)
+	observeQuery("GetBlockBloom", start, 1, err)
+	return bloom, err
+}
+
+func (b *metricsBackend) SaveBlockBloom(ctx context.Context, blockHash string, bloom string) error {
+	start := time.Now()
+	err := b.backend.SaveBlockBloom(ctx, blockHash, bloom)
+	observeQuery("SaveBlockBloom", start, 1, err)
+	return err
+}
+
+// observeQuery records the query, a missing record is an empty result rather than an error
+func observeQuery(method string, start time.Time, rows int, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		rows, err = 0, nil
+	}
+	metrics.ObserveQuery(method, start, rows, err)
+}
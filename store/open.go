@@ -0,0 +1,48 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// Open returns the backend of the given driver. For sqlite the db name is the database file path.
+func Open(driver string, config Config) (Backend, error) {
+	orm, err := OpenOrm(driver, config)
+	if err != nil {
+		return nil, err
+	}
+	return orm, nil
+}
+
+// OpenOrm returns the gorm orm of the given driver
+func OpenOrm(driver string, config Config) (*Orm, error) {
+	switch driver {
+	case DriverMySQL, "":
+		replicas, err := openReplicas("mysql", config, mysqlDSN)
+		if err != nil {
+			return nil, err
+		}
+		return newOrm(mysql.Open(mysqlDSN(config)), config, replicas...)
+	case DriverPostgres:
+		if _, _, err := splitHostPort(config.Addr); err != nil {
+			return nil, err
+		}
+		replicas, err := openReplicas("pgx", config, postgresDSN)
+		if err != nil {
+			return nil, err
+		}
+		return newOrm(postgres.Open(postgresDSN(config)), config, replicas...)
+	case DriverSQLite:
+		return newOrm(sqlite.Open(config.DBName), config)
+	}
+	return nil, fmt.Errorf("unsupported db driver %s", driver)
+}
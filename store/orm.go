@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/logger"
+	"github.com/okex/infura-service/tracing"
+	"gorm.io/gorm"
+)
+
+const maxSize = 10000
+
+// Orm is the Backend of a sql database
+type Orm struct {
+	db       *gorm.DB
+	replicas *replicaRouter // nil if there is no replica
+}
+
+// newOrm returns the orm of any gorm dialector, the queries are not specific to a database.
+// The read queries are served by the healthy replicas if there are any.
+func newOrm(dialector gorm.Dialector, config Config, replicas ...replicaDB) (*Orm, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Gorm(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	config.Pool.apply(sqlDB)
+	if err := db.Use(tracing.Gorm()); err != nil {
+		return nil, err
+	}
+	orm := &Orm{
+		db: db,
+	}
+	if len(replicas) > 0 {
+		for _, replica := range replicas {
+			config.Pool.apply(replica.DB)
+		}
+		orm.replicas = newReplicaRouter(replicas, config.ReplicaMaxLag)
+		if err := db.Use(orm.replicas); err != nil {
+			return nil, err
+		}
+	}
+	return orm, nil
+}
+
+// DB returns the underlying gorm db
+func (orm *Orm) DB() *gorm.DB {
+	return orm.db
+}
+
+func (orm *Orm) Ping(ctx context.Context) error {
+	sqlDB, err := orm.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close closes the primary and the replicas
+func (orm *Orm) Close() error {
+	if orm.replicas != nil {
+		if err := orm.replicas.close(); err != nil {
+			return err
+		}
+	}
+	sqlDB, err := orm.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (orm *Orm) GetTransactionReceipt(ctx context.Context, txHash string) (receipts []types.TransactionReceipt, err error) {
+	err = orm.db.WithContext(ctx).Preload("Logs.Topics").Preload("Logs").Where("transaction_hash =?",
+		txHash).Limit(1).Find(&receipts).Error // 这里使用Find而不是First的理由是：如果没有查询结果First会返回error
+	return
+}
+
+// GetBlockReceipts returns all receipts of the block with logs and topics in one query, ordered by transaction index
+func (orm *Orm) GetBlockReceipts(ctx context.Context, blockHash string) (receipts []types.TransactionReceipt, err error) {
+	err = orm.db.WithContext(ctx).Preload("Logs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("log_index")
+	}).Preload("Logs.Topics", orderByID).Where("block_hash=?", blockHash).Order("transaction_index").Find(&receipts).Error
+	return
+}
+
+func (orm *Orm) GetTransactionByHash(ctx context.Context, txHash string) (transactions []types.Transaction, err error) {
+	err = orm.db.WithContext(ctx).Where("hash=?", txHash).Limit(1).Find(&transactions).Error
+	return
+}
+
+// GetLogs returns the logs in the block range matching the addresses and topics, except the ones
+// of orphaned blocks, ordered by block and log index. limit <= 0 means no limit
+func (orm *Orm) GetLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, orphans []string, limit int) (logs []types.TransactionLog, err error) {
+	query := orm.db.WithContext(ctx).Preload("Topics", orderByID).Where("block_number >=? AND block_number<=?", fromBlock, toBlock)
+	if len(orphans) > 0 {
+		query = query.Where("block_hash NOT IN ?", orphans)
+	}
+	query = withTopics(withAddresses(query, addresses), topics)
+	err = withLimit(query, limit).Order("block_number, log_index").Find(&logs).Error
+	return
+}
+
+// supersededQuery matches the logs of the blocks indexed before another block at the same height
+const supersededQuery = `NOT EXISTS (SELECT 1 FROM blocks b JOIN blocks n ON n.number = b.number AND n.id > b.id
+AND n.deleted_at IS NULL WHERE b.hash = transaction_logs.block_hash)`
+
+// GetFinalLogs returns the logs of final heights in the block range matching the addresses and topics.
+// The last indexed block of a final height is canonical, the logs of the blocks it replaced are excluded.
+func (orm *Orm) GetFinalLogs(ctx context.Context, fromBlock, toBlock int64, addresses []string, topics [][]string, limit int) (logs []types.TransactionLog, err error) {
+	query := orm.db.WithContext(ctx).Preload("Topics", orderByID).Where("block_number >=? AND block_number<=?", fromBlock, toBlock).
+		Where(supersededQuery)
+	query = withTopics(withAddresses(query, addresses), topics)
+	err = withLimit(query, limit).Order("block_number, log_index").Find(&logs).Error
+	return
+}
+
+func (orm *Orm) GetLogsByBlockHash(ctx context.Context, blockHash string, addresses []string, topics [][]string, limit int) (logs []types.TransactionLog, err error) {
+	query := orm.db.WithContext(ctx).Preload("Topics", orderByID).Where("block_hash=?", blockHash)
+	query = withTopics(withAddresses(query, addresses), topics)
+	err = withLimit(query, limit).Order("log_index").Find(&logs).Error
+	return
+}
+
+// topicQuery matches the logs having one of the topics at the position, the position of a topic
+// is its order in the log, filled on insert since migration 6
+const topicQuery = `EXISTS (SELECT 1 FROM log_topics t WHERE t.transaction_log_id = transaction_logs.id
+AND t.deleted_at IS NULL AND t.topic IN ? AND t.position = ?)`
+
+// withTopics filters the logs by topics in sql, so that the limit applies after filtering.
+// topics[i] are the alternatives at position i, empty matches any topic.
+func withTopics(query *gorm.DB, topics [][]string) *gorm.DB {
+	for i, alternatives := range topics {
+		if len(alternatives) > 0 {
+			query = query.Where(topicQuery, alternatives, i)
+		}
+	}
+	return query
+}
+
+func orderByID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+func withAddresses(query *gorm.DB, addresses []string) *gorm.DB {
+	if len(addresses) == 1 {
+		return query.Where("address=?", addresses[0])
+	} else if len(addresses) > 1 {
+		return query.Where("address IN ?", addresses)
+	}
+	return query
+}
+
+func withLimit(query *gorm.DB, limit int) *gorm.DB {
+	if limit > 0 {
+		return query.Limit(limit)
+	}
+	return query
+}
+
+// GetBlockByNumber returns the block at the height, the last indexed one if there are several
+func (orm *Orm) GetBlockByNumber(ctx context.Context, blockNum int64) (block types.Block, err error) {
+	err = orm.db.WithContext(ctx).Preload("Transactions").Where("number=?", blockNum).Order("id DESC").First(&block).Error
+	return
+}
+
+// GetEarliestBlockNumber returns the lowest block number indexed, gorm.ErrRecordNotFound if there is none
+func (orm *Orm) GetEarliestBlockNumber(ctx context.Context) (number int64, err error) {
+	var numbers []sql.NullInt64
+	err = orm.db.WithContext(ctx).Model(&types.Block{}).Pluck("MIN(number)", &numbers).Error
+	if err == nil && (len(numbers) == 0 || !numbers[0].Valid) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err == nil {
+		number = numbers[0].Int64
+	}
+	return
+}
+
+// GetBlocksByRange returns the headers of all blocks in the range, including the orphaned ones
+func (orm *Orm) GetBlocksByRange(ctx context.Context, fromBlock, toBlock int64) (blocks []types.Block, err error) {
+	err = orm.db.WithContext(ctx).Select("id", "number", "hash", "parent_hash").Where("number >=? AND number<=?",
+		fromBlock, toBlock).Order("number, id").Limit(maxSize).Find(&blocks).Error
+	return
+}
+
+func (orm *Orm) GetBlockByHash(ctx context.Context, blockHash string) (block types.Block, err error) {
+	err = orm.db.WithContext(ctx).Preload("Transactions").Where("hash=?", blockHash).First(&block).Error
+	return
+}
+
+func (orm *Orm) GetContractCode(ctx context.Context, address string) (code types.ContractCode, err error) {
+	err = orm.db.WithContext(ctx).Where("address=?", address).First(&code).Error
+	return
+}
+
+// GetBlockBloom returns the logs bloom saved in blocks.logs_bloom, empty if not computed yet
+func (orm *Orm) GetBlockBloom(ctx context.Context, blockHash string) (bloom string, err error) {
+	// logs_bloom is null until computed
+	var blooms []sql.NullString
+	err = orm.db.WithContext(ctx).Model(&types.Block{}).Where("hash=?", blockHash).Limit(1).Pluck("logs_bloom", &blooms).Error
+	if err == nil && len(blooms) > 0 {
+		bloom = blooms[0].String
+	}
+	return
+}
+
+func (orm *Orm) SaveBlockBloom(ctx context.Context, blockHash string, bloom string) error {
+	return orm.db.WithContext(ctx).Model(&types.Block{}).Where("hash=?", blockHash).Update("logs_bloom", bloom).Error
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/okex/exchain/x/infura/types"
+	"github.com/okex/infura-service/migration"
+	"gorm.io/gorm"
+)
+
+const (
+	testAddress1 = "0x0000000000000000000000000000000000000001"
+	testAddress2 = "0x0000000000000000000000000000000000000002"
+	testTopicA   = "0x000000000000000000000000000000000000000000000000000000000000000a"
+	testTopicB   = "0x000000000000000000000000000000000000000000000000000000000000000b"
+	testTopicC   = "0x000000000000000000000000000000000000000000000000000000000000000c"
+)
+
+// newTestOrm returns an orm of a migrated sqlite database
+func newTestOrm(t *testing.T) *Orm {
+	return openTestOrm(t, filepath.Join(t.TempDir(), "infura.db"))
+}
+
+// openTestOrm returns an orm of the migrated sqlite database at the path
+func openTestOrm(t *testing.T, path string) *Orm {
+	orm, err := OpenOrm(DriverSQLite, Config{DBName: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migration.NewMigrator(orm.DB()).Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return orm
+}
+
+func testHash(prefix string, n int64) string {
+	return fmt.Sprintf("0x%s%063x", prefix, n)
+}
+
+// insertBlock saves the block at the height with one transaction per log, the logs are
+// given as their address and topics
+func insertBlock(t *testing.T, orm *Orm, height int64, fork string, logs ...types.TransactionLog) types.Block {
+	blockHash := testHash(fork, height)
+	block := types.Block{
+		Number:     height,
+		Hash:       blockHash,
+		ParentHash: testHash(fork, height-1),
+	}
+	for i, l := range logs {
+		txHash := testHash(fmt.Sprintf("%s%d", fork, i), height)
+		block.Transactions = append(block.Transactions, types.Transaction{
+			BlockHash:   blockHash,
+			BlockNumber: height,
+			Hash:        txHash,
+			Index:       uint64(i),
+		})
+		l.TransactionHash = txHash
+		l.TransactionIndex = uint64(i)
+		l.LogIndex = uint64(i)
+		l.BlockHash = blockHash
+		l.BlockNumber = height
+		receipt := types.TransactionReceipt{
+			Status:           1,
+			TransactionHash:  txHash,
+			BlockHash:        blockHash,
+			BlockNumber:      height,
+			TransactionIndex: uint64(i),
+			Logs:             []types.TransactionLog{l},
+		}
+		if err := orm.DB().Create(&receipt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := orm.DB().Create(&block).Error; err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func testLog(address string, topics ...string) types.TransactionLog {
+	l := types.TransactionLog{Address: address, Data: "0x"}
+	for _, topic := range topics {
+		l.Topics = append(l.Topics, types.LogTopic{Topic: topic})
+	}
+	return l
+}
+
+func TestOrmBlocks(t *testing.T) {
+	orm := newTestOrm(t)
+	ctx := context.Background()
+
+	if _, err := orm.GetEarliestBlockNumber(ctx); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("earliest of empty db: %v, want ErrRecordNotFound", err)
+	}
+	insertBlock(t, orm, 10, "a")
+	b11 := insertBlock(t, orm, 11, "a", testLog(testAddress1, testTopicA))
+	insertBlock(t, orm, 12, "a")
+	// block 12 is replaced by a fork, the last indexed one wins
+	b12 := insertBlock(t, orm, 12, "b")
+
+	if earliest, err := orm.GetEarliestBlockNumber(ctx); err != nil || earliest != 10 {
+		t.Fatalf("earliest %d %v, want 10", earliest, err)
+	}
+	block, err := orm.GetBlockByNumber(ctx, 12)
+	if err != nil || block.Hash != b12.Hash {
+		t.Fatalf("block 12 is %s %v, want %s", block.Hash, err, b12.Hash)
+	}
+	block, err = orm.GetBlockByHash(ctx, b11.Hash)
+	if err != nil || block.Number != 11 || len(block.Transactions) != 1 {
+		t.Fatalf("block by hash: %+v %v", block, err)
+	}
+	if _, err := orm.GetBlockByNumber(ctx, 13); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("block 13: %v, want ErrRecordNotFound", err)
+	}
+	blocks, err := orm.GetBlocksByRange(ctx, 11, 12)
+	if err != nil || len(blocks) != 3 {
+		t.Fatalf("got %d blocks %v, want 3 including the orphan", len(blocks), err)
+	}
+
+	if bloom, err := orm.GetBlockBloom(ctx, b11.Hash); err != nil || bloom != "" {
+		t.Fatalf("bloom of block 11 %q %v, want empty until saved", bloom, err)
+	}
+	if err := orm.SaveBlockBloom(ctx, b11.Hash, "0x1234"); err != nil {
+		t.Fatal(err)
+	}
+	if bloom, err := orm.GetBlockBloom(ctx, b11.Hash); err != nil || bloom != "0x1234" {
+		t.Fatalf("bloom %q %v", bloom, err)
+	}
+}
+
+func TestOrmTransactions(t *testing.T) {
+	orm := newTestOrm(t)
+	ctx := context.Background()
+
+	block := insertBlock(t, orm, 1, "a",
+		testLog(testAddress1, testTopicA, testTopicB),
+		testLog(testAddress2, testTopicC))
+	txHash := block.Transactions[1].Hash
+
+	txs, err := orm.GetTransactionByHash(ctx, txHash)
+	if err != nil || len(txs) != 1 || txs[0].Index != 1 {
+		t.Fatalf("transaction: %+v %v", txs, err)
+	}
+	if txs, err := orm.GetTransactionByHash(ctx, testHash("f", 1)); err != nil || len(txs) != 0 {
+		t.Fatalf("unknown transaction: %+v %v", txs, err)
+	}
+
+	receipts, err := orm.GetTransactionReceipt(ctx, block.Transactions[0].Hash)
+	if err != nil || len(receipts) != 1 {
+		t.Fatalf("receipt: %+v %v", receipts, err)
+	}
+	if logs := receipts[0].Logs; len(logs) != 1 || len(logs[0].Topics) != 2 || logs[0].Topics[1].Topic != testTopicB {
+		t.Fatalf("receipt logs: %+v", logs)
+	}
+
+	receipts, err = orm.GetBlockReceipts(ctx, block.Hash)
+	if err != nil || len(receipts) != 2 {
+		t.Fatalf("block receipts: %+v %v", receipts, err)
+	}
+	for i, receipt := range receipts {
+		if receipt.TransactionIndex != uint64(i) || len(receipt.Logs) != 1 {
+			t.Fatalf("receipt %d: %+v", i, receipt)
+		}
+	}
+
+	if err := orm.DB().Create(&types.ContractCode{Address: testAddress1, Code: "0x60", BlockNumber: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code, err := orm.GetContractCode(ctx, testAddress1); err != nil || code.Code != "0x60" {
+		t.Fatalf("code: %+v %v", code, err)
+	}
+	if _, err := orm.GetContractCode(ctx, testAddress2); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("code of %s: %v, want ErrRecordNotFound", testAddress2, err)
+	}
+}
+
+func TestOrmLogs(t *testing.T) {
+	orm := newTestOrm(t)
+	ctx := context.Background()
+
+	insertBlock(t, orm, 1, "a",
+		testLog(testAddress1, testTopicA, testTopicB),
+		testLog(testAddress2, testTopicB, testTopicA))
+	b2 := insertBlock(t, orm, 2, "a",
+		testLog(testAddress1, testTopicA),
+		testLog(testAddress1, testTopicC, testTopicB))
+	orphan := insertBlock(t, orm, 2, "b", testLog(testAddress1, testTopicA))
+
+	tests := []struct {
+		name      string
+		addresses []string
+		topics    [][]string
+		orphans   []string
+		limit     int
+		want      []string // block hash prefix and log index of each log
+	}{
+		{name: "all", want: []string{"a1/0", "a1/1", "a2/0", "b2/0", "a2/1"}},
+		{name: "orphans", orphans: []string{orphan.Hash}, want: []string{"a1/0", "a1/1", "a2/0", "a2/1"}},
+		{name: "address", addresses: []string{testAddress2}, want: []string{"a1/1"}},
+		{name: "addresses", addresses: []string{testAddress1, testAddress2}, orphans: []string{orphan.Hash}, want: []string{"a1/0", "a1/1", "a2/0", "a2/1"}},
+		{name: "first topic", topics: [][]string{{testTopicA}}, orphans: []string{orphan.Hash}, want: []string{"a1/0", "a2/0"}},
+		{name: "second topic", topics: [][]string{nil, {testTopicB}}, want: []string{"a1/0", "a2/1"}},
+		{name: "alternatives", topics: [][]string{{testTopicB, testTopicC}}, want: []string{"a1/1", "a2/1"}},
+		{name: "both positions", topics: [][]string{{testTopicA}, {testTopicB}}, want: []string{"a1/0"}},
+		{name: "limit after filter", topics: [][]string{{testTopicA}}, orphans: []string{orphan.Hash}, limit: 1, want: []string{"a1/0"}},
+		{name: "no match", topics: [][]string{nil, nil, {testTopicA}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := orm.GetLogs(ctx, 1, 2, tt.addresses, tt.topics, tt.orphans, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, l := range logs {
+				got = append(got, fmt.Sprintf("%s%d/%d", l.BlockHash[2:3], l.BlockNumber, l.LogIndex))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// b2 is indexed after a2, so it is canonical once final
+	logs, err := orm.GetFinalLogs(ctx, 1, 2, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range logs {
+		got = append(got, fmt.Sprintf("%s%d/%d", l.BlockHash[2:3], l.BlockNumber, l.LogIndex))
+	}
+	if want := []string{"a1/0", "a1/1", "b2/0"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("final logs %v, want %v", got, want)
+	}
+
+	logs, err = orm.GetLogsByBlockHash(ctx, b2.Hash, []string{testAddress1}, [][]string{{testTopicC}}, 0)
+	if err != nil || len(logs) != 1 || logs[0].LogIndex != 1 {
+		t.Fatalf("logs by block hash: %+v %v", logs, err)
+	}
+	if topics := logs[0].Topics; len(topics) != 2 || topics[0].Topic != testTopicC || topics[1].Topic != testTopicB {
+		t.Fatalf("topics are not in order: %+v", topics)
+	}
+}
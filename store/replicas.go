@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/okex/infura-service/metrics"
+	"gorm.io/gorm"
+)
+
+const (
+	replicaCheckInterval = 5 * time.Second
+	replicaPingTimeout   = 2 * time.Second
+	// 检查周期内查询数不少于replicaMinQueries且错误率超过replicaMaxErrorRate时摘除
+	replicaMinQueries   = 10
+	replicaMaxErrorRate = 0.5
+)
+
+// replicaDB is a read replica of the database, the name is used in logs and metrics
+type replicaDB struct {
+	Name string
+	DB   *sql.DB
+}
+
+// openReplicas returns the replicas of the config, dsn returns the dsn of a config with the replica address
+func openReplicas(driverName string, config Config, dsn func(Config) string) ([]replicaDB, error) {
+	replicas := make([]replicaDB, 0, len(config.Replicas))
+	for _, addr := range config.Replicas {
+		c := config
+		c.Addr = addr
+		db, err := sql.Open(driverName, dsn(c))
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replicaDB{Name: addr, DB: db})
+	}
+	return replicas, nil
+}
+
+type replica struct {
+	replicaDB
+	healthy int32 // 1 if healthy, 0 if ejected, -1 before the first check
+	queries int64
+	errors  int64
+}
+
+// replicaRouter routes the read queries to the healthy replicas in the way of gorm dbresolver. The
+// primary is used for writes, in transactions, for locking reads and if no replica is healthy. The
+// replicas are pinged and ejected on failure, high error rate or when their latest block is more than
+// maxLag blocks behind the primary, and restored once they pass the check again.
+type replicaRouter struct {
+	primary  gorm.ConnPool
+	replicas []*replica
+	maxLag   int64 // 0 to disable the lag check
+	next     uint32
+	done     chan struct{}
+}
+
+func newReplicaRouter(replicas []replicaDB, maxLag int64) *replicaRouter {
+	r := &replicaRouter{
+		maxLag: maxLag,
+		done:   make(chan struct{}),
+	}
+	for _, rep := range replicas {
+		r.replicas = append(r.replicas, &replica{replicaDB: rep, healthy: -1})
+	}
+	return r
+}
+
+func (r *replicaRouter) Name() string {
+	return "store:replicas"
+}
+
+func (r *replicaRouter) Initialize(db *gorm.DB) error {
+	r.primary = db.ConnPool
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("store:route_query", r.route); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("store:route_row", r.route); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("gorm:raw").Register("store:route_raw", r.route); err != nil {
+		return err
+	}
+	if err := cb.Query().After("gorm:query").Register("store:record_query", r.record); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("store:record_row", r.record); err != nil {
+		return err
+	}
+	if err := cb.Raw().After("gorm:raw").Register("store:record_raw", r.record); err != nil {
+		return err
+	}
+	r.check()
+	go r.loop()
+	return nil
+}
+
+// route switches the read query to a replica. The preload queries keep the connection
+// pool of their query, so that a query and its preloads read from the same replica.
+func (r *replicaRouter) route(db *gorm.DB) {
+	if db.Statement.ConnPool != r.primary {
+		return
+	}
+	if _, locking := db.Statement.Clauses["FOR"]; locking {
+		return
+	}
+	if rawSQL := strings.TrimSpace(db.Statement.SQL.String()); rawSQL != "" && !isRead(rawSQL) {
+		return
+	}
+	if rep := r.pick(); rep != nil {
+		db.Statement.ConnPool = rep.DB
+	}
+}
+
+func isRead(rawSQL string) bool {
+	return len(rawSQL) > 6 && strings.EqualFold(rawSQL[:6], "select") &&
+		!strings.HasSuffix(strings.ToLower(rawSQL), "for update")
+}
+
+// pick returns the next healthy replica in turn, nil if there is none
+func (r *replicaRouter) pick() *replica {
+	n := len(r.replicas)
+	start := int(atomic.AddUint32(&r.next, 1))
+	for i := 0; i < n; i++ {
+		if rep := r.replicas[(start+i)%n]; atomic.LoadInt32(&rep.healthy) == 1 {
+			return rep
+		}
+	}
+	return nil
+}
+
+// record counts the queries and errors of the replica
+func (r *replicaRouter) record(db *gorm.DB) {
+	for _, rep := range r.replicas {
+		if db.Statement.ConnPool != rep.DB {
+			continue
+		}
+		atomic.AddInt64(&rep.queries, 1)
+		if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, context.Canceled) {
+			atomic.AddInt64(&rep.errors, 1)
+		}
+		return
+	}
+}
+
+func (r *replicaRouter) loop() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+// close stops the checks and closes the replicas
+func (r *replicaRouter) close() error {
+	close(r.done)
+	var err error
+	for _, rep := range r.replicas {
+		if e := rep.DB.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// rowQuerier is the primary or a replica
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// latestBlock returns the latest block number in the database, 0 if there is no block
+func latestBlock(db rowQuerier) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+	var number sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT MAX(number) FROM blocks").Scan(&number)
+	return number.Int64, err
+}
+
+// check pings the replicas, computes their error rates since the last check and compares their
+// latest block to the primary. The lag is not checked if the primary can not be read.
+func (r *replicaRouter) check() {
+	var latest int64
+	checkLag := false
+	if r.maxLag > 0 {
+		var err error
+		if latest, err = latestBlock(r.primary); err != nil {
+			log.Warn("failed to get latest block of db primary, skip the replica lag check", "err", err)
+		} else {
+			checkLag = true
+		}
+	}
+	for _, rep := range r.replicas {
+		queries := atomic.SwapInt64(&rep.queries, 0)
+		errs := atomic.SwapInt64(&rep.errors, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+		err := rep.DB.PingContext(ctx)
+		cancel()
+		if err == nil && queries >= replicaMinQueries && float64(errs)/float64(queries) > replicaMaxErrorRate {
+			err = errors.New("error rate too high")
+		}
+		if err == nil && checkLag {
+			var height int64
+			if height, err = latestBlock(rep.DB); err == nil && latest-height > r.maxLag {
+				err = fmt.Errorf("replication lag of %d blocks", latest-height)
+			}
+		}
+
+		healthy := int32(0)
+		if err == nil {
+			healthy = 1
+		}
+		if prev := atomic.SwapInt32(&rep.healthy, healthy); prev != 0 && healthy == 0 {
+			log.Warn("db replica ejected", "replica", rep.Name, "queries", queries, "errors", errs, "err", err)
+		} else if prev == 0 && healthy == 1 {
+			log.Info("db replica restored", "replica", rep.Name)
+		}
+		metrics.SetReplicaHealthy(rep.Name, healthy == 1)
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestReplica returns a migrated sqlite replica with the blocks 1 to height of the fork,
+// and an orm of the replica alone to write it directly
+func newTestReplica(t *testing.T, name, fork string, height int64) (replicaDB, *Orm) {
+	orm := openTestOrm(t, filepath.Join(t.TempDir(), name+".db"))
+	for i := int64(1); i <= height; i++ {
+		insertBlock(t, orm, i, fork)
+	}
+	sqlDB, err := orm.DB().DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replicaDB{Name: name, DB: sqlDB}, orm
+}
+
+// newReplicatedOrm returns the orm of a primary with the blocks 1 to height of fork "p" and the
+// replicas, and an orm of the primary alone to read it directly
+func newReplicatedOrm(t *testing.T, height, maxLag int64, replicas ...replicaDB) (*Orm, *Orm) {
+	path := filepath.Join(t.TempDir(), "primary.db")
+	primary := openTestOrm(t, path)
+	for i := int64(1); i <= height; i++ {
+		insertBlock(t, primary, i, "p")
+	}
+	orm, err := newOrm(sqlite.Open(path), Config{ReplicaMaxLag: maxLag}, replicas...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orm, primary
+}
+
+func replicaHealthy(orm *Orm, i int) bool {
+	return atomic.LoadInt32(&orm.replicas.replicas[i].healthy) == 1
+}
+
+func TestReplicaRouting(t *testing.T) {
+	r1, _ := newTestReplica(t, "r1", "a", 10)
+	r2, _ := newTestReplica(t, "r2", "b", 10)
+	orm, primary := newReplicatedOrm(t, 10, 0, r1, r2)
+	defer orm.Close()
+	ctx := context.Background()
+
+	// the reads take the replicas in turn
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		block, err := orm.GetBlockByNumber(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[block.Hash] = true
+	}
+	if len(seen) != 2 || !seen[testHash("a", 10)] || !seen[testHash("b", 10)] {
+		t.Fatalf("read blocks %v, want the block of both replicas", seen)
+	}
+
+	// the writes go to the primary
+	if err := orm.SaveBlockBloom(ctx, testHash("p", 10), "0x1234"); err != nil {
+		t.Fatal(err)
+	}
+	if bloom, err := primary.GetBlockBloom(ctx, testHash("p", 10)); err != nil || bloom != "0x1234" {
+		t.Fatalf("bloom of the primary %q %v", bloom, err)
+	}
+
+	// the reads in a transaction stay on the primary
+	var hash string
+	err := orm.DB().Transaction(func(tx *gorm.DB) error {
+		return tx.Raw("SELECT hash FROM blocks WHERE number = ?", 10).Scan(&hash).Error
+	})
+	if err != nil || hash != testHash("p", 10) {
+		t.Fatalf("read %s %v in a transaction, want the block of the primary", hash, err)
+	}
+}
+
+func TestReplicaEjectOnLag(t *testing.T) {
+	r1, replica := newTestReplica(t, "r1", "a", 10)
+	orm, _ := newReplicatedOrm(t, 20, 5, r1)
+	defer orm.Close()
+	ctx := context.Background()
+
+	if replicaHealthy(orm, 0) {
+		t.Fatal("replica 10 blocks behind is healthy, want ejected")
+	}
+	block, err := orm.GetBlockByNumber(ctx, 10)
+	if err != nil || block.Hash != testHash("p", 10) {
+		t.Fatalf("read %s %v, want the block of the primary", block.Hash, err)
+	}
+
+	// restored once it is within the max lag
+	for i := int64(11); i <= 15; i++ {
+		insertBlock(t, replica, i, "a")
+	}
+	orm.replicas.check()
+	if !replicaHealthy(orm, 0) {
+		t.Fatal("replica 5 blocks behind is ejected, want restored")
+	}
+	block, err = orm.GetBlockByNumber(ctx, 10)
+	if err != nil || block.Hash != testHash("a", 10) {
+		t.Fatalf("read %s %v, want the block of the replica", block.Hash, err)
+	}
+}
+
+func TestReplicaEjectOnPingFailure(t *testing.T) {
+	r1, _ := newTestReplica(t, "r1", "a", 10)
+	orm, _ := newReplicatedOrm(t, 10, 0, r1)
+	defer orm.Close()
+	ctx := context.Background()
+
+	if !replicaHealthy(orm, 0) {
+		t.Fatal("replica is ejected, want healthy")
+	}
+	// the replica is down
+	if err := r1.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	orm.replicas.check()
+	if replicaHealthy(orm, 0) {
+		t.Fatal("closed replica is healthy, want ejected")
+	}
+	block, err := orm.GetBlockByNumber(ctx, 10)
+	if err != nil || block.Hash != testHash("p", 10) {
+		t.Fatalf("read %s %v, want the block of the primary", block.Hash, err)
+	}
+}
+
+func TestReplicaClose(t *testing.T) {
+	r1, _ := newTestReplica(t, "r1", "a", 10)
+	orm, _ := newReplicatedOrm(t, 10, 0, r1)
+
+	if err := orm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-orm.replicas.done:
+	default:
+		t.Fatal("the replica check loop is not stopped")
+	}
+	if err := r1.DB.Ping(); err == nil {
+		t.Fatal("replica is not closed")
+	}
+	if err := orm.Ping(context.Background()); err == nil {
+		t.Fatal("primary is not closed")
+	}
+}
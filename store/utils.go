@@ -0,0 +1,14 @@
+package store
+
+import (
+	"fmt"
+	"net"
+)
+
+func splitHostPort(url string) (string, string, error) {
+	host, port, err := net.SplitHostPort(url)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid db url %s: %s", url, err.Error())
+	}
+	return host, port, nil
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const jsonrpcSystem = "jsonrpc"
+
+// rpcError is the error of a call with a json-rpc error code
+type rpcError interface {
+	ErrorCode() int
+}
+
+// StartCall starts the span of a json-rpc call in the context passed to the method. The rpc server
+// runs the calls of a batch one by one in the context of the http request, so the span is started
+// by the method and the sql and redis spans of the call are its children.
+func StartCall(ctx context.Context, method string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, method,
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String(jsonrpcSystem),
+			semconv.RPCMethodKey.String(method),
+		))
+}
+
+// EndCall ends the span of a json-rpc call with the error of the method
+func EndCall(span trace.Span, err error) {
+	if err != nil {
+		if e, ok := err.(rpcError); ok {
+			span.SetAttributes(semconv.RPCJsonrpcErrorCodeKey.Int(e.ErrorCode()))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}